@@ -0,0 +1,156 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"delivery_webservice/auth"
+	"delivery_webservice/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	errRoleExists      = errors.New("account already has this role")
+	errPhoneRegistered = errors.New("phone number is registered with a different password")
+)
+
+// AccountRole is one role an account holds, with the ID of its profile row
+type AccountRole struct {
+	Role string `json:"role"`
+	ID   int    `json:"id"` // uid, rid or admin id
+}
+
+// accountDetails is what login needs to know about an account
+type accountDetails struct {
+	AccountID    int // zero when no account was found
+	PasswordHash string
+	Status       string
+	Suspended    bool
+	Roles        []AccountRole
+}
+
+// roleID returns the profile ID of role, if the account holds it
+func (a accountDetails) roleID(role string) (int, bool) {
+	for _, r := range a.Roles {
+		if r.Role == role {
+			return r.ID, true
+		}
+	}
+	return 0, false
+}
+
+// accountTable returns the profile table and ID column for a role
+func accountTable(role string) (string, string, error) {
+	switch role {
+	case auth.RoleUser:
+		return "Users", "uid", nil
+	case auth.RoleRider:
+		return "Riders", "rid", nil
+	case auth.RoleAdmin:
+		return "Admins", "aid", nil
+	}
+	return "", "", fmt.Errorf("unknown role %q", role)
+}
+
+// loadAccountRoles returns the roles an account holds, users first, then riders, then admins
+func loadAccountRoles(db *sql.DB, accountID int) ([]AccountRole, error) {
+	rows, err := db.Query(`
+		SELECT 'user', uid FROM Users WHERE account_id = ?
+		UNION ALL SELECT 'rider', rid FROM Riders WHERE account_id = ?
+		UNION ALL SELECT 'admin', aid FROM Admins WHERE account_id = ?`,
+		accountID, accountID, accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []AccountRole
+	for rows.Next() {
+		var role AccountRole
+		if err := rows.Scan(&role.Role, &role.ID); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// getAccountByPhone retrieves the account, its hashed password, status and roles for a given phone number.
+// Pending accounts whose verification window has passed are treated as not found.
+func getAccountByPhone(db *sql.DB, phone string) (accountDetails, error) {
+	var account accountDetails
+	var verifyExpiresAt, suspendedAt sql.NullTime
+	err := db.QueryRow(
+		"SELECT account_id, password, status, verify_expires_at, suspended_at FROM Accounts WHERE phone_number = ?",
+		phone,
+	).Scan(&account.AccountID, &account.PasswordHash, &account.Status, &verifyExpiresAt, &suspendedAt)
+	if err == sql.ErrNoRows {
+		return accountDetails{}, nil // Not found
+	} else if err != nil {
+		return accountDetails{}, err
+	}
+
+	if account.Status == accountStatusPending && verifyExpiresAt.Valid && time.Now().After(verifyExpiresAt.Time) {
+		return accountDetails{}, nil
+	}
+	account.Suspended = suspendedAt.Valid
+
+	account.Roles, err = loadAccountRoles(db, account.AccountID)
+	if err != nil {
+		return accountDetails{}, err
+	}
+	return account, nil
+}
+
+// claimAccountForRole returns the account a new role profile should belong to, creating a
+// pending account when the phone number is new. An existing account can only take on another
+// role when the caller knows its password. The caller inserts the profile row in the same tx.
+func claimAccountForRole(tx *sql.Tx, phone, password, role string) (int, string, bool, error) {
+	table, _, err := accountTable(role)
+	if err != nil {
+		return 0, "", false, err
+	}
+
+	var accountID int
+	var hashedPassword, status string
+	err = tx.QueryRow(
+		"SELECT account_id, password, status FROM Accounts WHERE phone_number = ? FOR UPDATE",
+		phone,
+	).Scan(&accountID, &hashedPassword, &status)
+	if err == nil {
+		var hasRole bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM "+table+" WHERE account_id = ?)", accountID).Scan(&hasRole); err != nil {
+			return 0, "", false, err
+		}
+		if hasRole {
+			return 0, "", false, errRoleExists
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+			return 0, "", false, errPhoneRegistered
+		}
+		return accountID, status, false, nil
+	} else if err != sql.ErrNoRows {
+		return 0, "", false, err
+	}
+
+	hashedPassword, err = hashPassword(password)
+	if err != nil {
+		return 0, "", false, err
+	}
+	result, err := tx.Exec(
+		"INSERT INTO Accounts (phone_number, password, status, verify_expires_at) VALUES (?, ?, ?, ?)",
+		phone, hashedPassword, accountStatusPending, time.Now().UTC().Add(config.PendingAccountTTL),
+	)
+	if err != nil {
+		return 0, "", false, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, "", false, err
+	}
+	return int(id), accountStatusPending, true, nil
+}
@@ -0,0 +1,450 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSavedAddresses is how many addresses one user may keep
+const maxSavedAddresses = 20
+
+var errAddressNotFound = errors.New("address not found")
+
+// SavedAddress is an entry in a user's address book
+type SavedAddress struct {
+	ID          int       `json:"id"`
+	Label       string    `json:"label"`
+	Address     string    `json:"address"`
+	Location    LatLng    `json:"location"`
+	ContactName string    `json:"contact_name"`
+	RiderNotes  string    `json:"rider_notes"`
+	IsDefault   bool      `json:"is_default"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SavedAddressRequest creates an address, or on PATCH changes only the fields that are present
+type SavedAddressRequest struct {
+	Label       *string `json:"label"`
+	Address     *string `json:"address"`
+	Location    *LatLng `json:"location"`
+	ContactName *string `json:"contact_name"`
+	RiderNotes  *string `json:"rider_notes"`
+	IsDefault   *bool   `json:"is_default"`
+}
+
+var savedAddressColumns = "id, label, address, " + latLngColumns("location") + ", contact_name, rider_notes, is_default, created_at, updated_at"
+
+// scanSavedAddress reads a row selected with savedAddressColumns
+func scanSavedAddress(row interface{ Scan(...interface{}) error }) (SavedAddress, error) {
+	var a SavedAddress
+	err := row.Scan(&a.ID, &a.Label, &a.Address, &a.Location.Lat, &a.Location.Lng,
+		&a.ContactName, &a.RiderNotes, &a.IsDefault, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+// validate trims the fields that are present and returns a message for each invalid one.
+// When creating, label, address and location are required.
+func (req *SavedAddressRequest) validate(creating bool) map[string]string {
+	fields := map[string]string{}
+	for _, f := range []*string{req.Label, req.Address, req.ContactName, req.RiderNotes} {
+		if f != nil {
+			*f = trimSpace(*f)
+		}
+	}
+
+	if req.Label != nil && *req.Label == "" || creating && req.Label == nil {
+		fields["label"] = "cannot be empty"
+	} else if req.Label != nil && len(*req.Label) > 50 {
+		fields["label"] = "must be at most 50 characters"
+	}
+	if req.Address != nil && *req.Address == "" || creating && req.Address == nil {
+		fields["address"] = "cannot be empty"
+	} else if req.Address != nil && len(*req.Address) > 255 {
+		fields["address"] = "must be at most 255 characters"
+	}
+	if req.Location == nil {
+		if creating {
+			fields["location"] = "is required"
+		}
+	} else if msg := req.Location.validate(); msg != "" {
+		fields["location"] = msg
+	}
+	if req.ContactName != nil && len(*req.ContactName) > 255 {
+		fields["contact_name"] = "must be at most 255 characters"
+	}
+	if req.RiderNotes != nil && len(*req.RiderNotes) > 500 {
+		fields["rider_notes"] = "must be at most 500 characters"
+	}
+	return fields
+}
+
+// addressIDFromPath reads {address_id} from the URL
+func addressIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["address_id"])
+	if err != nil || id <= 0 {
+		writeError(w, "Invalid address ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// getSavedAddress returns an address of uid, or errAddressNotFound
+func getSavedAddress(db *sql.DB, uid, addressID int) (SavedAddress, error) {
+	a, err := scanSavedAddress(db.QueryRow(
+		"SELECT "+savedAddressColumns+" FROM User_Addresses WHERE id = ? AND uid = ?",
+		addressID, uid,
+	))
+	if err == sql.ErrNoRows {
+		return SavedAddress{}, errAddressNotFound
+	}
+	return a, err
+}
+
+// ListAddresses returns the address book of the logged-in user, default first
+func ListAddresses(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query(
+			"SELECT "+savedAddressColumns+" FROM User_Addresses WHERE uid = ? ORDER BY is_default DESC, label, id",
+			caller.ID,
+		)
+		if err != nil {
+			log.Println("Error listing addresses:", err)
+			writeError(w, "Failed to retrieve addresses", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		addresses := []SavedAddress{}
+		for rows.Next() {
+			a, err := scanSavedAddress(rows)
+			if err != nil {
+				log.Println("Error scanning address:", err)
+				writeError(w, "Failed to retrieve addresses", http.StatusInternalServerError)
+				return
+			}
+			addresses = append(addresses, a)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(addresses)
+	}
+}
+
+// GetAddress returns one saved address of the logged-in user
+func GetAddress(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		addressID, ok := addressIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		a, err := getSavedAddress(db, caller.ID, addressID)
+		if errors.Is(err, errAddressNotFound) {
+			writeError(w, "Address not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error loading address:", err)
+			writeError(w, "Failed to retrieve address", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// CreateAddress adds an address to the logged-in user's book. The first address becomes the default.
+func CreateAddress(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		var req SavedAddressRequest
+		if !decodePatch(w, r, &req) {
+			return
+		}
+		if fields := req.validate(true); len(fields) > 0 {
+			writeFieldErrors(w, fields)
+			return
+		}
+		contactName, riderNotes := "", ""
+		if req.ContactName != nil {
+			contactName = *req.ContactName
+		}
+		if req.RiderNotes != nil {
+			riderNotes = *req.RiderNotes
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error saving address", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Lock the user's row so two requests cannot both pass the limit or both become default
+		var count int
+		if err := tx.QueryRow("SELECT uid FROM Users WHERE uid = ? FOR UPDATE", caller.ID).Scan(new(int)); err != nil {
+			log.Println("Error locking user:", err)
+			writeError(w, "Error saving address", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.QueryRow("SELECT COUNT(*) FROM User_Addresses WHERE uid = ?", caller.ID).Scan(&count); err != nil {
+			log.Println("Error counting addresses:", err)
+			writeError(w, "Error saving address", http.StatusInternalServerError)
+			return
+		}
+		if count >= maxSavedAddresses {
+			writeError(w, "Address book is full", http.StatusConflict)
+			return
+		}
+
+		isDefault := count == 0 || (req.IsDefault != nil && *req.IsDefault)
+		if isDefault {
+			if _, err := tx.Exec("UPDATE User_Addresses SET is_default = FALSE WHERE uid = ? AND is_default", caller.ID); err != nil {
+				log.Println("Error clearing default address:", err)
+				writeError(w, "Error saving address", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		result, err := tx.Exec(
+			"INSERT INTO User_Addresses (uid, label, address, location, contact_name, rider_notes, is_default) VALUES (?, ?, ?, "+pointFromText+", ?, ?, ?)",
+			caller.ID, *req.Label, *req.Address, req.Location.wkt(), contactName, riderNotes, isDefault,
+		)
+		if err != nil {
+			log.Println("Error saving address:", err)
+			writeError(w, "Error saving address", http.StatusInternalServerError)
+			return
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			log.Println("Error retrieving address ID:", err)
+			writeError(w, "Error saving address", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing address:", err)
+			writeError(w, "Error saving address", http.StatusInternalServerError)
+			return
+		}
+
+		a, err := getSavedAddress(db, caller.ID, int(id))
+		if err != nil {
+			log.Println("Error loading address:", err)
+			writeError(w, "Error saving address", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// UpdateAddress changes the fields present in the body. Shipments already created from the
+// address keep the copy they took. Setting is_default moves the default to this address; the
+// default cannot be unset directly, only moved.
+func UpdateAddress(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		addressID, ok := addressIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req SavedAddressRequest
+		if !decodePatch(w, r, &req) {
+			return
+		}
+		fields := req.validate(false)
+		if req.IsDefault != nil && !*req.IsDefault {
+			fields["is_default"] = "choose another address as default instead"
+		}
+		if len(fields) > 0 {
+			writeFieldErrors(w, fields)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error updating address", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var exists bool
+		err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM User_Addresses WHERE id = ? AND uid = ? FOR UPDATE)", addressID, caller.ID).Scan(&exists)
+		if err != nil {
+			log.Println("Error loading address:", err)
+			writeError(w, "Error updating address", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			writeError(w, "Address not found", http.StatusNotFound)
+			return
+		}
+
+		var sets []string
+		var args []interface{}
+		for _, f := range []struct {
+			column string
+			value  *string
+		}{
+			{"label", req.Label},
+			{"address", req.Address},
+			{"contact_name", req.ContactName},
+			{"rider_notes", req.RiderNotes},
+		} {
+			if f.value != nil {
+				sets = append(sets, f.column+" = ?")
+				args = append(args, *f.value)
+			}
+		}
+		if req.Location != nil {
+			sets = append(sets, "location = "+pointFromText)
+			args = append(args, req.Location.wkt())
+		}
+		if req.IsDefault != nil {
+			if _, err := tx.Exec("UPDATE User_Addresses SET is_default = FALSE WHERE uid = ? AND is_default AND id <> ?", caller.ID, addressID); err != nil {
+				log.Println("Error clearing default address:", err)
+				writeError(w, "Error updating address", http.StatusInternalServerError)
+				return
+			}
+			sets = append(sets, "is_default = TRUE")
+		}
+		if len(sets) > 0 {
+			args = append(args, addressID)
+			if _, err := tx.Exec("UPDATE User_Addresses SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+				log.Println("Error updating address:", err)
+				writeError(w, "Error updating address", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing address:", err)
+			writeError(w, "Error updating address", http.StatusInternalServerError)
+			return
+		}
+
+		a, err := getSavedAddress(db, caller.ID, addressID)
+		if err != nil {
+			log.Println("Error loading address:", err)
+			writeError(w, "Error updating address", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+// DeleteAddress removes a saved address. Shipments created from it keep their copy. When the
+// default is removed, the oldest remaining address becomes the default.
+func DeleteAddress(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		addressID, ok := addressIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error deleting address", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var wasDefault bool
+		err = tx.QueryRow("SELECT is_default FROM User_Addresses WHERE id = ? AND uid = ? FOR UPDATE", addressID, caller.ID).Scan(&wasDefault)
+		if err == sql.ErrNoRows {
+			writeError(w, "Address not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error loading address:", err)
+			writeError(w, "Error deleting address", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := tx.Exec("DELETE FROM User_Addresses WHERE id = ?", addressID); err != nil {
+			log.Println("Error deleting address:", err)
+			writeError(w, "Error deleting address", http.StatusInternalServerError)
+			return
+		}
+		if wasDefault {
+			_, err := tx.Exec("UPDATE User_Addresses SET is_default = TRUE WHERE uid = ? ORDER BY id LIMIT 1", caller.ID)
+			if err != nil {
+				log.Println("Error choosing new default address:", err)
+				writeError(w, "Error deleting address", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing address deletion:", err)
+			writeError(w, "Error deleting address", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Address deleted",
+		})
+	}
+}
+
+// resolveShipmentAddress picks the saved address a shipment stop is copied from: the requested one,
+// which must be one of the caller's own, otherwise the default address of defaultOwner. Addresses of
+// other people are never taken by id, so a sender cannot copy someone else's address by guessing it.
+// Zero means there is none and the stop keeps the profile address.
+func resolveShipmentAddress(tx *sql.Tx, requested *int, caller, defaultOwner int) (int, error) {
+	if requested != nil {
+		var uid int
+		err := tx.QueryRow("SELECT uid FROM User_Addresses WHERE id = ?", *requested).Scan(&uid)
+		if err == sql.ErrNoRows || (err == nil && uid != caller) {
+			return 0, errAddressNotFound
+		} else if err != nil {
+			return 0, err
+		}
+		return *requested, nil
+	}
+
+	if defaultOwner == 0 {
+		return 0, nil
+	}
+	var id int
+	err := tx.QueryRow("SELECT id FROM User_Addresses WHERE uid = ? AND is_default", defaultOwner).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
@@ -0,0 +1,456 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Values of Account_Suspensions.action
+const (
+	suspensionActionSuspend   = "suspend"
+	suspensionActionReinstate = "reinstate"
+)
+
+// accountStatusSuspended is reported for accounts with suspended_at set; it is not stored in Accounts.status
+const accountStatusSuspended = "suspended"
+
+// AdminAccount is an account as listed for operations staff
+type AdminAccount struct {
+	AccountID        int           `json:"account_id"`
+	PhoneNumber      string        `json:"phone_number"`
+	Name             string        `json:"name"`
+	Status           string        `json:"status"` // "pending", "active" or "suspended"
+	SuspendedAt      *time.Time    `json:"suspended_at,omitempty"`
+	SuspensionReason *string       `json:"suspension_reason,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+	Roles            []AccountRole `json:"roles"`
+}
+
+// AccountSuspension is one entry of an account's suspension history
+type AccountSuspension struct {
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason"`
+	AdminID   int       `json:"admin_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AdminAccountDetail is the full view of one account for operations staff
+type AdminAccountDetail struct {
+	AdminAccount
+	Address          string              `json:"address,omitempty"`
+	LicensePlate     string              `json:"license_plate,omitempty"`
+	ShipmentsSent    int                 `json:"shipments_sent"`
+	ShipmentsAsRider int                 `json:"shipments_as_rider"`
+	History          []AccountSuspension `json:"history"`
+}
+
+// SuspensionRequest carries the reason an admin gives for suspending or reinstating an account
+type SuspensionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// validate checks that a reason was given
+func (req *SuspensionRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Reason = trimSpace(req.Reason)
+	if requireField(fields, "reason", req.Reason) {
+		validateMaxLen(fields, "reason", req.Reason, 500)
+	}
+	return fields
+}
+
+const adminAccountSelect = `
+	SELECT a.account_id, a.phone_number, COALESCE(u.name, r.name, d.name, ''), a.status,
+		a.suspended_at, a.suspension_reason, a.created_at
+	FROM Accounts a
+	LEFT JOIN Users u ON u.account_id = a.account_id
+	LEFT JOIN Riders r ON r.account_id = a.account_id
+	LEFT JOIN Admins d ON d.account_id = a.account_id`
+
+// scanAdminAccount reads one row selected with adminAccountSelect
+func scanAdminAccount(row interface{ Scan(...interface{}) error }) (AdminAccount, error) {
+	var account AdminAccount
+	var suspendedAt sql.NullTime
+	var reason sql.NullString
+	err := row.Scan(&account.AccountID, &account.PhoneNumber, &account.Name, &account.Status,
+		&suspendedAt, &reason, &account.CreatedAt)
+	if err != nil {
+		return AdminAccount{}, err
+	}
+	if suspendedAt.Valid {
+		account.Status = accountStatusSuspended
+		account.SuspendedAt = &suspendedAt.Time
+		account.SuspensionReason = &reason.String
+	}
+	return account, nil
+}
+
+// accountIDFromPath reads {account_id} from the URL
+func accountIDFromPath(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["account_id"])
+	return id, err == nil && id > 0
+}
+
+// ListAccounts lists accounts for admins. Query parameters:
+// q (phone prefix or name), role (user, rider or admin), status (pending, active or suspended), limit, offset.
+func ListAccounts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		var conditions []string
+		var args []interface{}
+
+		if q := trimSpace(params.Get("q")); q != "" {
+			// Phone numbers are stored in E.164, so "0812" has to match "+66812..."
+			phonePrefix := searchPhonePrefix(q)
+			if phonePrefix == "" {
+				phonePrefix = q
+			}
+			conditions = append(conditions, "(a.phone_number LIKE ? OR u.name LIKE ? OR r.name LIKE ?)")
+			args = append(args, phonePrefix+"%", "%"+q+"%", "%"+q+"%")
+		}
+
+		switch params.Get("role") {
+		case "":
+		case "user":
+			conditions = append(conditions, "u.uid IS NOT NULL")
+		case "rider":
+			conditions = append(conditions, "r.rid IS NOT NULL")
+		case "admin":
+			conditions = append(conditions, "d.aid IS NOT NULL")
+		default:
+			writeError(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
+
+		switch status := params.Get("status"); status {
+		case "":
+		case accountStatusSuspended:
+			conditions = append(conditions, "a.suspended_at IS NOT NULL")
+		case accountStatusActive, accountStatusPending:
+			conditions = append(conditions, "a.status = ? AND a.suspended_at IS NULL")
+			args = append(args, status)
+		default:
+			writeError(w, "Unknown status", http.StatusBadRequest)
+			return
+		}
+
+		limit := 50
+		if v, err := strconv.Atoi(params.Get("limit")); err == nil && v > 0 && v <= 200 {
+			limit = v
+		}
+		offset := 0
+		if v, err := strconv.Atoi(params.Get("offset")); err == nil && v > 0 {
+			offset = v
+		}
+
+		query := adminAccountSelect
+		if len(conditions) > 0 {
+			query += " WHERE " + strings.Join(conditions, " AND ")
+		}
+		query += " ORDER BY a.account_id DESC LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Println("Error listing accounts:", err)
+			writeError(w, "Failed to retrieve accounts", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		accounts := []AdminAccount{}
+		for rows.Next() {
+			account, err := scanAdminAccount(rows)
+			if err != nil {
+				log.Println("Error scanning account:", err)
+				writeError(w, "Failed to retrieve accounts", http.StatusInternalServerError)
+				return
+			}
+			accounts = append(accounts, account)
+		}
+
+		for i := range accounts {
+			accounts[i].Roles, err = loadAccountRoles(db, accounts[i].AccountID)
+			if err != nil {
+				log.Println("Error loading account roles:", err)
+				writeError(w, "Failed to retrieve accounts", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(accounts)
+	}
+}
+
+// GetAccount returns one account with its profiles, shipment counts and suspension history
+func GetAccount(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, ok := accountIDFromPath(r)
+		if !ok {
+			writeError(w, "Invalid account ID", http.StatusBadRequest)
+			return
+		}
+
+		account, err := scanAdminAccount(db.QueryRow(adminAccountSelect+" WHERE a.account_id = ?", accountID))
+		if err == sql.ErrNoRows {
+			writeError(w, "Account not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error fetching account:", err)
+			writeError(w, "Failed to retrieve account", http.StatusInternalServerError)
+			return
+		}
+
+		detail := AdminAccountDetail{AdminAccount: account, History: []AccountSuspension{}}
+		if detail.Roles, err = loadAccountRoles(db, accountID); err != nil {
+			log.Println("Error loading account roles:", err)
+			writeError(w, "Failed to retrieve account", http.StatusInternalServerError)
+			return
+		}
+
+		err = db.QueryRow(`
+			SELECT
+				COALESCE((SELECT address FROM Users WHERE account_id = ?), ''),
+				COALESCE((SELECT license_plate FROM Riders WHERE account_id = ?), ''),
+				(SELECT COUNT(*) FROM Shipments s JOIN Users u ON u.uid = s.sender_id WHERE u.account_id = ?),
+				(SELECT COUNT(*) FROM Shipments s JOIN Riders r ON r.rid = s.rider_id WHERE r.account_id = ?)`,
+			accountID, accountID, accountID, accountID,
+		).Scan(&detail.Address, &detail.LicensePlate, &detail.ShipmentsSent, &detail.ShipmentsAsRider)
+		if err != nil {
+			log.Println("Error fetching account profile:", err)
+			writeError(w, "Failed to retrieve account", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err := db.Query(
+			"SELECT action, reason, admin_id, created_at FROM Account_Suspensions WHERE account_id = ? ORDER BY id DESC",
+			accountID,
+		)
+		if err != nil {
+			log.Println("Error fetching suspension history:", err)
+			writeError(w, "Failed to retrieve account", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var entry AccountSuspension
+			if err := rows.Scan(&entry.Action, &entry.Reason, &entry.AdminID, &entry.CreatedAt); err != nil {
+				log.Println("Error scanning suspension history:", err)
+				writeError(w, "Failed to retrieve account", http.StatusInternalServerError)
+				return
+			}
+			detail.History = append(detail.History, entry)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(detail)
+	}
+}
+
+// decodeSuspensionReason reads the required reason from the request body
+func decodeSuspensionReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req SuspensionRequest
+	if !decodeJSON(w, r, &req) {
+		return "", false
+	}
+	return req.Reason, true
+}
+
+// SuspendAccount blocks an account from logging in and from every authenticated route,
+// logs it out everywhere and flags the shipments it holds as a rider
+func SuspendAccount(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		accountID, ok := accountIDFromPath(r)
+		if !ok {
+			writeError(w, "Invalid account ID", http.StatusBadRequest)
+			return
+		}
+		if accountID == caller.AccountID {
+			writeError(w, "Admins cannot suspend their own account", http.StatusBadRequest)
+			return
+		}
+		reason, ok := decodeSuspensionReason(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error suspending account", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var suspendedAt sql.NullTime
+		err = tx.QueryRow("SELECT suspended_at FROM Accounts WHERE account_id = ? FOR UPDATE", accountID).Scan(&suspendedAt)
+		if err == sql.ErrNoRows {
+			writeError(w, "Account not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error fetching account:", err)
+			writeError(w, "Error suspending account", http.StatusInternalServerError)
+			return
+		}
+		if suspendedAt.Valid {
+			writeError(w, "Account is already suspended", http.StatusConflict)
+			return
+		}
+
+		now := time.Now().UTC()
+		if _, err := tx.Exec(
+			"UPDATE Accounts SET suspended_at = ?, suspension_reason = ? WHERE account_id = ?",
+			now, reason, accountID,
+		); err != nil {
+			log.Println("Error suspending account:", err)
+			writeError(w, "Error suspending account", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := tx.Exec(
+			"INSERT INTO Account_Suspensions (account_id, action, reason, admin_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			accountID, suspensionActionSuspend, reason, caller.ID, now,
+		); err != nil {
+			log.Println("Error recording suspension:", err)
+			writeError(w, "Error suspending account", http.StatusInternalServerError)
+			return
+		}
+
+		// Flag the shipments this account is carrying as a rider so senders and staff can see them
+		result, err := tx.Exec(`
+			UPDATE Shipments s
+			JOIN Riders r ON r.rid = s.rider_id
+			SET s.rider_suspended_at = ?
+			WHERE r.account_id = ? AND s.status IN (`+shipmentStatusList(activeShipmentStatuses)+`)`,
+			now, accountID,
+		)
+		if err != nil {
+			log.Println("Error flagging rider shipments:", err)
+			writeError(w, "Error suspending account", http.StatusInternalServerError)
+			return
+		}
+		flagged, _ := result.RowsAffected()
+
+		if _, err := tx.Exec(
+			"UPDATE Sessions SET revoked_at = ? WHERE account_id = ? AND revoked_at IS NULL",
+			now, accountID,
+		); err != nil {
+			log.Println("Error revoking sessions:", err)
+			writeError(w, "Error suspending account", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing suspension:", err)
+			writeError(w, "Error suspending account", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, auditAdminSuspend, accountID, caller.AccountID, map[string]interface{}{"reason": reason, "flagged_shipments": flagged})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":           "Account suspended",
+			"account_id":        accountID,
+			"flagged_shipments": flagged,
+		})
+	}
+}
+
+// ReinstateAccount lifts a suspension and clears the flag on the account's rider shipments
+func ReinstateAccount(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		accountID, ok := accountIDFromPath(r)
+		if !ok {
+			writeError(w, "Invalid account ID", http.StatusBadRequest)
+			return
+		}
+		reason, ok := decodeSuspensionReason(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error reinstating account", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var suspendedAt sql.NullTime
+		err = tx.QueryRow("SELECT suspended_at FROM Accounts WHERE account_id = ? FOR UPDATE", accountID).Scan(&suspendedAt)
+		if err == sql.ErrNoRows {
+			writeError(w, "Account not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error fetching account:", err)
+			writeError(w, "Error reinstating account", http.StatusInternalServerError)
+			return
+		}
+		if !suspendedAt.Valid {
+			writeError(w, "Account is not suspended", http.StatusConflict)
+			return
+		}
+
+		now := time.Now().UTC()
+		if _, err := tx.Exec(
+			"UPDATE Accounts SET suspended_at = NULL, suspension_reason = NULL WHERE account_id = ?",
+			accountID,
+		); err != nil {
+			log.Println("Error reinstating account:", err)
+			writeError(w, "Error reinstating account", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := tx.Exec(
+			"INSERT INTO Account_Suspensions (account_id, action, reason, admin_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			accountID, suspensionActionReinstate, reason, caller.ID, now,
+		); err != nil {
+			log.Println("Error recording reinstatement:", err)
+			writeError(w, "Error reinstating account", http.StatusInternalServerError)
+			return
+		}
+
+		// Finished shipments keep the flag as the record of the suspension
+		if _, err := tx.Exec(`
+			UPDATE Shipments s
+			JOIN Riders r ON r.rid = s.rider_id
+			SET s.rider_suspended_at = NULL
+			WHERE r.account_id = ? AND s.status IN (`+shipmentStatusList(activeShipmentStatuses)+`)`,
+			accountID,
+		); err != nil {
+			log.Println("Error clearing rider shipment flags:", err)
+			writeError(w, "Error reinstating account", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing reinstatement:", err)
+			writeError(w, "Error reinstating account", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, auditAdminReinstate, accountID, caller.AccountID, map[string]interface{}{"reason": reason})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":    "Account reinstated",
+			"account_id": accountID,
+		})
+	}
+}
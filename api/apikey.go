@@ -0,0 +1,264 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"delivery_webservice/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// apiKeyPrefix marks merchant API keys so they are easy to spot in logs and secret scanners
+const apiKeyPrefix = "dk_"
+
+var errAPIKeyInvalid = errors.New("api key is invalid or revoked")
+
+// APIKey describes an issued key without its secret
+type APIKey struct {
+	ID           int        `json:"id"`
+	KeyPrefix    string     `json:"key_prefix"`
+	AccountID    int        `json:"account_id"`
+	UserID       int        `json:"user_id"`
+	Name         string     `json:"name"`
+	Scopes       []string   `json:"scopes"`
+	CreatedBy    int        `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	RequestCount int64      `json:"request_count"`
+}
+
+// CreateAPIKeyRequest issues a key for the sender account account_id
+type CreateAPIKeyRequest struct {
+	AccountID int      `json:"account_id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+}
+
+// validate trims the name and checks every scope
+func (req *CreateAPIKeyRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Name = trimSpace(req.Name)
+	if req.AccountID <= 0 {
+		fields["account_id"] = "is required"
+	}
+	if requireField(fields, "name", req.Name) {
+		validateMaxLen(fields, "name", req.Name, 100)
+	}
+	if len(req.Scopes) == 0 {
+		fields["scopes"] = "is required"
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			fields["scopes"] = "unknown scope " + scope
+			break
+		}
+	}
+	return fields
+}
+
+// authenticateAPIKey resolves a key to the sender it acts as and counts the request against it.
+// The second result reports whether the key's account is suspended.
+func authenticateAPIKey(db *sql.DB, key string) (auth.Identity, bool, error) {
+	var identity auth.Identity
+	var scopes string
+	var suspendedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT k.id, k.account_id, k.user_id, k.scopes, a.suspended_at
+		FROM Api_Keys k
+		JOIN Accounts a ON a.account_id = k.account_id
+		WHERE k.key_hash = ? AND k.revoked_at IS NULL`,
+		hashToken(key),
+	).Scan(&identity.APIKeyID, &identity.AccountID, &identity.ID, &scopes, &suspendedAt)
+	if err == sql.ErrNoRows {
+		return auth.Identity{}, false, errAPIKeyInvalid
+	} else if err != nil {
+		return auth.Identity{}, false, err
+	}
+
+	identity.Role = auth.RoleUser
+	identity.Scopes = strings.Split(scopes, ",")
+
+	_, err = db.Exec(
+		"UPDATE Api_Keys SET last_used_at = UTC_TIMESTAMP(), request_count = request_count + 1 WHERE id = ?",
+		identity.APIKeyID,
+	)
+	if err != nil {
+		log.Println("Error recording API key usage:", err)
+	}
+	return identity, suspendedAt.Valid, nil
+}
+
+// CreateAPIKey issues a new key for an active sender account. The key is only returned in this response.
+func CreateAPIKey(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		var req CreateAPIKeyRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		// Only accounts that can log in themselves may be given a key
+		var status string
+		var deleted, suspended bool
+		err := db.QueryRow(
+			"SELECT status, deleted_at IS NOT NULL, suspended_at IS NOT NULL FROM Accounts WHERE account_id = ?", req.AccountID,
+		).Scan(&status, &deleted, &suspended)
+		if err == sql.ErrNoRows {
+			writeError(w, "Account not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error fetching account:", err)
+			writeError(w, "Error creating API key", http.StatusInternalServerError)
+			return
+		}
+		if status != accountStatusActive || deleted || suspended {
+			writeError(w, "Account is not active", http.StatusConflict)
+			return
+		}
+
+		// Keys act as the account's sender profile
+		var userID int
+		err = db.QueryRow("SELECT uid FROM Users WHERE account_id = ?", req.AccountID).Scan(&userID)
+		if err == sql.ErrNoRows {
+			writeError(w, "Account has no sender profile", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println("Error fetching sender profile:", err)
+			writeError(w, "Error creating API key", http.StatusInternalServerError)
+			return
+		}
+
+		secret, err := newRandomToken(32)
+		if err != nil {
+			log.Println("Error generating API key:", err)
+			writeError(w, "Error creating API key", http.StatusInternalServerError)
+			return
+		}
+		key := apiKeyPrefix + secret
+
+		result, err := db.Exec(
+			"INSERT INTO Api_Keys (key_prefix, key_hash, account_id, user_id, name, scopes, created_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			key[:11], hashToken(key), req.AccountID, userID, req.Name, strings.Join(req.Scopes, ","), caller.ID,
+		)
+		if err != nil {
+			log.Println("Error creating API key:", err)
+			writeError(w, "Error creating API key", http.StatusInternalServerError)
+			return
+		}
+		keyID, _ := result.LastInsertId()
+		recordAudit(db, r, auditAdminAPIKeyCreate, req.AccountID, caller.AccountID, map[string]interface{}{"key_id": keyID, "scopes": req.Scopes})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "API key created; store it now, it will not be shown again",
+			"id":      keyID,
+			"key":     key,
+			"scopes":  req.Scopes,
+		})
+	}
+}
+
+// ListAPIKeys lists issued keys with their usage, optionally filtered by ?account_id=
+func ListAPIKeys(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := `
+			SELECT id, key_prefix, account_id, user_id, name, scopes, created_by, created_at,
+				revoked_at, last_used_at, request_count
+			FROM Api_Keys`
+		var args []interface{}
+		if v := r.URL.Query().Get("account_id"); v != "" {
+			accountID, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(w, "Invalid account ID", http.StatusBadRequest)
+				return
+			}
+			query += " WHERE account_id = ?"
+			args = append(args, accountID)
+		}
+		query += " ORDER BY id DESC"
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Println("Error listing API keys:", err)
+			writeError(w, "Failed to retrieve API keys", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		keys := []APIKey{}
+		for rows.Next() {
+			var key APIKey
+			var scopes string
+			var revokedAt, lastUsedAt sql.NullTime
+			err := rows.Scan(&key.ID, &key.KeyPrefix, &key.AccountID, &key.UserID, &key.Name, &scopes,
+				&key.CreatedBy, &key.CreatedAt, &revokedAt, &lastUsedAt, &key.RequestCount)
+			if err != nil {
+				log.Println("Error scanning API key:", err)
+				writeError(w, "Failed to retrieve API keys", http.StatusInternalServerError)
+				return
+			}
+			key.Scopes = strings.Split(scopes, ",")
+			if revokedAt.Valid {
+				key.RevokedAt = &revokedAt.Time
+			}
+			if lastUsedAt.Valid {
+				key.LastUsedAt = &lastUsedAt.Time
+			}
+			keys = append(keys, key)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// RevokeAPIKey stops a key from working; revoked keys stay listed for their usage history
+func RevokeAPIKey(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		keyID, err := strconv.Atoi(mux.Vars(r)["key_id"])
+		if err != nil {
+			writeError(w, "Invalid key ID", http.StatusBadRequest)
+			return
+		}
+
+		result, err := db.Exec("UPDATE Api_Keys SET revoked_at = UTC_TIMESTAMP() WHERE id = ? AND revoked_at IS NULL", keyID)
+		if err != nil {
+			log.Println("Error revoking API key:", err)
+			writeError(w, "Error revoking API key", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			writeError(w, "API key not found or already revoked", http.StatusNotFound)
+			return
+		}
+
+		var accountID int
+		if err := db.QueryRow("SELECT account_id FROM Api_Keys WHERE id = ?", keyID).Scan(&accountID); err != nil {
+			log.Println("Error fetching revoked API key:", err)
+		}
+		recordAudit(db, r, auditAdminAPIKeyRevoke, accountID, caller.AccountID, map[string]interface{}{"key_id": keyID})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "API key revoked",
+		})
+	}
+}
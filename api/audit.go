@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"delivery_webservice/config"
+)
+
+// Audit events written to Audit_Log
+const (
+	auditLoginSuccess      = "login.success"
+	auditLoginFailure      = "login.failure"
+	auditRegister          = "account.register"
+	auditPhoneVerified     = "account.phone_verified"
+	auditPhoneChange       = "account.phone_change"
+	auditPasswordChange    = "password.change"
+	auditPasswordReset     = "password.reset"
+	auditRoleSwitch        = "role.switch"
+	auditLogout            = "session.logout"
+	auditLogoutAll         = "session.logout_all"
+	auditDataExport        = "account.data_export"
+	auditAccountDeleted    = "account.deleted"
+	auditAdminSuspend      = "admin.account.suspend"
+	auditAdminReinstate    = "admin.account.reinstate"
+	auditAdminAPIKeyCreate = "admin.api_key.create"
+	auditAdminAPIKeyRevoke = "admin.api_key.revoke"
+
+	auditRiderVerificationSubmit = "rider.verification_submit"
+	auditAdminRiderApprove       = "admin.rider.approve"
+	auditAdminRiderReject        = "admin.rider.reject"
+	auditAdminRiderDocumentView  = "admin.rider.document_view"
+)
+
+// AuditEntry is one row of the audit log
+type AuditEntry struct {
+	ID             int64           `json:"id"`
+	Event          string          `json:"event"`
+	AccountID      *int            `json:"account_id"`
+	ActorAccountID *int            `json:"actor_account_id,omitempty"`
+	IP             string          `json:"ip"`
+	UserAgent      string          `json:"user_agent"`
+	Details        json.RawMessage `json:"details,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// auditPhone stands in for a phone number in audit details. The audit log is append-only and outlives
+// account erasure, so it never holds the number itself; the keyed hash still lets support staff match
+// entries against a number they are given.
+func auditPhone(phone string) string {
+	mac := hmac.New(sha256.New, config.JWTSecret)
+	mac.Write([]byte("audit|" + phone))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// nullableID stores zero IDs as NULL
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// recordAudit appends an event to the audit log. accountID is the account the event is about
+// and actorAccountID the one that caused it (zero when they are the same or unknown).
+// Failures are logged and never fail the request.
+func recordAudit(db *sql.DB, r *http.Request, event string, accountID, actorAccountID int, details map[string]interface{}) {
+	var detailsJSON interface{}
+	if len(details) > 0 {
+		b, err := json.Marshal(details)
+		if err != nil {
+			log.Println("Error encoding audit details:", err)
+		} else {
+			detailsJSON = string(b)
+		}
+	}
+
+	_, err := db.Exec(
+		"INSERT INTO Audit_Log (event, account_id, actor_account_id, ip, user_agent, details, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		event, nullableID(accountID), nullableID(actorAccountID), clientIP(r), truncate(r.UserAgent(), 255), detailsJSON, time.Now().UTC(),
+	)
+	if err != nil {
+		log.Printf("Error writing audit event %s: %v", event, err)
+	}
+}
+
+// ListAuditLog lets admins query the audit log. Query parameters: account_id, event,
+// from and to (RFC 3339 times), limit (default 100, at most 1000) and before_id for paging.
+func ListAuditLog(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		var conditions []string
+		var args []interface{}
+
+		if v := params.Get("account_id"); v != "" {
+			accountID, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(w, "Invalid account ID", http.StatusBadRequest)
+				return
+			}
+			conditions = append(conditions, "(account_id = ? OR actor_account_id = ?)")
+			args = append(args, accountID, accountID)
+		}
+		if event := trimSpace(params.Get("event")); event != "" {
+			conditions = append(conditions, "event = ?")
+			args = append(args, event)
+		}
+		for _, bound := range []struct{ param, condition string }{
+			{"from", "created_at >= ?"},
+			{"to", "created_at < ?"},
+		} {
+			v := params.Get(bound.param)
+			if v == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, "Invalid "+bound.param+" time, use RFC 3339", http.StatusBadRequest)
+				return
+			}
+			conditions = append(conditions, bound.condition)
+			args = append(args, t.UTC())
+		}
+		if v := params.Get("before_id"); v != "" {
+			beforeID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeError(w, "Invalid before_id", http.StatusBadRequest)
+				return
+			}
+			conditions = append(conditions, "id < ?")
+			args = append(args, beforeID)
+		}
+
+		limit := 100
+		if v, err := strconv.Atoi(params.Get("limit")); err == nil && v > 0 && v <= 1000 {
+			limit = v
+		}
+
+		query := "SELECT id, event, account_id, actor_account_id, ip, user_agent, details, created_at FROM Audit_Log"
+		if len(conditions) > 0 {
+			query += " WHERE " + strings.Join(conditions, " AND ")
+		}
+		query += " ORDER BY id DESC LIMIT ?"
+		args = append(args, limit)
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Println("Error querying audit log:", err)
+			writeError(w, "Failed to retrieve audit log", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		entries := []AuditEntry{}
+		for rows.Next() {
+			var entry AuditEntry
+			var accountID, actorAccountID sql.NullInt64
+			var details sql.NullString
+			err := rows.Scan(&entry.ID, &entry.Event, &accountID, &actorAccountID, &entry.IP, &entry.UserAgent, &details, &entry.CreatedAt)
+			if err != nil {
+				log.Println("Error scanning audit entry:", err)
+				writeError(w, "Failed to retrieve audit log", http.StatusInternalServerError)
+				return
+			}
+			if accountID.Valid {
+				id := int(accountID.Int64)
+				entry.AccountID = &id
+			}
+			if actorAccountID.Valid {
+				id := int(actorAccountID.Int64)
+				entry.ActorAccountID = &id
+			}
+			if details.Valid {
+				entry.Details = json.RawMessage(details.String)
+			}
+			entries = append(entries, entry)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error codes sent in the "code" field of error responses. Clients branch on these, never on the
+// message, so existing codes must not be renamed.
+const (
+	codeBadRequest       = "bad_request"
+	codeInvalidJSON      = "invalid_json"
+	codeValidation       = "validation_failed" // see the fields map for what to fix
+	codeUnauthorized     = "unauthorized"
+	codeInvalidToken     = "invalid_token"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeTooLarge         = "payload_too_large"
+	codeUnsupportedMedia = "unsupported_media_type"
+	codeRateLimited      = "rate_limited"
+	codeInternal         = "internal_error"
+
+	codeInvalidCredentials = "invalid_credentials"
+	codeInvalidCode        = "invalid_code" // wrong or expired SMS code
+	codePhoneNotVerified   = "phone_not_verified"
+	codeAccountSuspended   = "account_suspended"
+	codeDocumentsMissing   = "documents_missing"
+	codeRiderNotApproved   = "rider_not_approved"
+	codeIllegalTransition  = "illegal_transition" // the shipment's current status does not allow the change
+	codeShipmentTaken      = "shipment_taken"
+	codeActiveJobLimit     = "active_job_limit"
+	codeProofRequired      = "proof_required" // pickup and delivery go through the proof upload
+)
+
+// statusCodes is the code used for a status when the handler does not pick a more specific one
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeBadRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+	http.StatusConflict:              codeConflict,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusUnsupportedMediaType:  codeUnsupportedMedia,
+	http.StatusTooManyRequests:       codeRateLimited,
+}
+
+// APIError is the body of every error response, wrapped as {"error": {...}}
+type APIError struct {
+	Status  int               `json:"-"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"` // field name in the request body -> what is wrong with it
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ErrorResponse is the JSON envelope around an APIError
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// writeAPIError sends e in the error envelope. Server errors always get the generic code, and the
+// message the handler chose, so details of what failed inside stay in the log.
+func writeAPIError(w http.ResponseWriter, e *APIError) {
+	if e.Status >= 500 {
+		e.Code = codeInternal
+		e.Fields = nil
+	} else if e.Code == "" {
+		e.Code = statusCodes[e.Status]
+		if e.Code == "" {
+			e.Code = codeBadRequest
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: e})
+}
+
+// writeError is the JSON replacement for http.Error; the code follows from the status
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeAPIError(w, &APIError{Status: status, Message: message})
+}
+
+// writeErrorCode is writeError with a code more specific than the status alone
+func writeErrorCode(w http.ResponseWriter, message string, status int, code string) {
+	writeAPIError(w, &APIError{Status: status, Code: code, Message: message})
+}
+
+// writeFieldErrors answers 400 with a message for each invalid field
+func writeFieldErrors(w http.ResponseWriter, fields map[string]string) {
+	writeAPIError(w, &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    codeValidation,
+		Message: "Invalid input",
+		Fields:  fields,
+	})
+}
+
+// NotFound answers requests that match no route
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, "Not found", http.StatusNotFound)
+}
+
+// MethodNotAllowed answers requests to a known path with a method it does not accept
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// validator is implemented by request bodies. validate trims and normalizes the fields in place and
+// returns a message for each invalid one, keyed by its JSON name; an empty map means the body is valid.
+type validator interface {
+	validate() map[string]string
+}
+
+// decodeJSON reads a JSON request body into dst and, when dst is a validator, validates it.
+// It writes the error response and returns false when the body is malformed or invalid.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	return decodeBody(w, r, dst, false)
+}
+
+// decodePatch is decodeJSON for partial updates. Unknown fields are refused, so that a misspelt
+// field is reported instead of silently changing nothing.
+func decodePatch(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	return decodeBody(w, r, dst, true)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, dst interface{}, strict bool) bool {
+	decoder := json.NewDecoder(r.Body)
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(dst); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			writeFieldErrors(w, map[string]string{typeErr.Field: "must be " + jsonTypeName(typeErr.Type.Kind().String())})
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+			writeFieldErrors(w, map[string]string{field: "is not a known field"})
+		case errors.Is(err, io.EOF):
+			writeErrorCode(w, "Request body is empty", http.StatusBadRequest, codeInvalidJSON)
+		default:
+			writeErrorCode(w, "Request body is not valid JSON", http.StatusBadRequest, codeInvalidJSON)
+		}
+		return false
+	}
+	if v, ok := dst.(validator); ok {
+		if fields := v.validate(); len(fields) > 0 {
+			writeFieldErrors(w, fields)
+			return false
+		}
+	}
+	return true
+}
+
+// jsonTypeName names a Go kind the way a JSON client would think of it
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "string":
+		return "a string"
+	case kind == "bool":
+		return "true or false"
+	case kind == "slice", kind == "array":
+		return "a list"
+	case kind == "struct", kind == "map", kind == "ptr":
+		return "an object"
+	}
+	return fmt.Sprintf("a %s", kind)
+}
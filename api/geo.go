@@ -0,0 +1,62 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+
+	"delivery_webservice/config"
+)
+
+// Locations are stored as WGS 84 points (SRID 4326). Read them back with ST_Latitude and
+// ST_Longitude: with a geographic SRID, ST_X returns the latitude, not the longitude.
+const locationSRID = 4326
+
+// pointFromText is the SQL that turns a LatLng.wkt() argument into a stored point
+var pointFromText = fmt.Sprintf("ST_PointFromText(?, %d, 'axis-order=long-lat')", locationSRID)
+
+// LatLng is a location as sent and returned by the API
+type LatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// wkt returns the point as WKT in longitude-latitude order, for pointFromText
+func (p LatLng) wkt() string {
+	return fmt.Sprintf("POINT(%f %f)", p.Lng, p.Lat)
+}
+
+// inBounds reports whether the point is inside the configured service area
+func (p LatLng) inBounds() bool {
+	return p.Lat >= config.GeoMinLat && p.Lat <= config.GeoMaxLat &&
+		p.Lng >= config.GeoMinLng && p.Lng <= config.GeoMaxLng
+}
+
+// validate returns a message when the point is outside the service area
+func (p LatLng) validate() string {
+	if p.inBounds() {
+		return ""
+	}
+	if (LatLng{Lat: p.Lng, Lng: p.Lat}).inBounds() {
+		return "lat and lng look swapped"
+	}
+	return fmt.Sprintf("must be inside the service area: lat %g to %g, lng %g to %g",
+		config.GeoMinLat, config.GeoMaxLat, config.GeoMinLng, config.GeoMaxLng)
+}
+
+// latLngColumns selects the latitude and longitude of a point column, for scanning into nullLatLng
+func latLngColumns(column string) string {
+	return fmt.Sprintf("ST_Latitude(%s), ST_Longitude(%s)", column, column)
+}
+
+// nullLatLng scans the two columns of latLngColumns when the point may be NULL
+type nullLatLng struct {
+	Lat, Lng sql.NullFloat64
+}
+
+// ptr returns the point, or nil when it was NULL
+func (n nullLatLng) ptr() *LatLng {
+	if !n.Lat.Valid || !n.Lng.Valid {
+		return nil
+	}
+	return &LatLng{Lat: n.Lat.Float64, Lng: n.Lng.Float64}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errBadImage = errors.New("image data is malformed")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripImageMetadata removes location and camera metadata from a JPEG or PNG without re-encoding it.
+// The whole Exif block goes, not only the GPS tags: timestamps and camera serial numbers identify
+// people too.
+func stripImageMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	}
+	return nil, errBadImage
+}
+
+// stripJPEGMetadata drops APP1 segments (Exif and XMP) that come before the image data
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errBadImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	pos := 2
+	for {
+		if pos+2 > len(data) || data[pos] != 0xFF {
+			return nil, errBadImage
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF: // fill byte
+			pos++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // no length field
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		case marker == 0xDA: // start of scan: the rest is image data
+			out.Write(data[pos:])
+			return out.Bytes(), nil
+		case marker == 0xD9: // end of image
+			out.Write(data[pos : pos+2])
+			return out.Bytes(), nil
+		}
+
+		if pos+4 > len(data) {
+			return nil, errBadImage
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:pos+4]))
+		if end > len(data) || end < pos+4 {
+			return nil, errBadImage
+		}
+		if marker != 0xE1 {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+}
+
+// stripPNGMetadata drops the eXIf chunk and text chunks, which is where PNG keeps Exif and XMP
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errBadImage
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errBadImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		chunkType := string(data[pos+4 : pos+8])
+		end := pos + 12 + length // length, type, data and CRC
+		if length < 0 || end > len(data) || end < pos {
+			return nil, errBadImage
+		}
+		switch chunkType {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+		if chunkType == "IEND" {
+			return out.Bytes(), nil
+		}
+	}
+	return nil, errBadImage
+}
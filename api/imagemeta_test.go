@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gpsExif stands in for an Exif block carrying GPS tags; the stripper drops it without parsing
+var gpsExif = []byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08GPSLatitude 13.7563 GPSLongitude 100.5018")
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 60), uint8(y * 60), 128, 255})
+		}
+	}
+	return img
+}
+
+func encodeTestJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeTestPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// jpegSegment builds a marker segment with its length field
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// withJPEGSegments inserts segments right after the SOI marker
+func withJPEGSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	for _, seg := range segments {
+		out = append(out, seg...)
+	}
+	return append(out, data[2:]...)
+}
+
+// pngChunk builds a chunk with its length and CRC
+func pngChunk(chunkType string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, payload...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+// withPNGChunks inserts chunks right after IHDR, which is always the first chunk
+func withPNGChunks(data []byte, chunks ...[]byte) []byte {
+	afterIHDR := len(pngSignature) + 12 + 13
+	out := append([]byte{}, data[:afterIHDR]...)
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return append(out, data[afterIHDR:]...)
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	plainJPEG := encodeTestJPEG(t)
+	plainPNG := encodeTestPNG(t)
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPSLatitude</x:xmpmeta>")
+	comment := jpegSegment(0xFE, []byte("kept comment"))
+
+	tests := []struct {
+		name        string
+		contentType string
+		in          []byte
+		want        []byte
+	}{
+		{"jpeg without metadata", "image/jpeg", plainJPEG, plainJPEG},
+		{"jpeg exif", "image/jpeg", withJPEGSegments(plainJPEG, jpegSegment(0xE1, gpsExif)), plainJPEG},
+		{"jpeg exif and xmp", "image/jpeg",
+			withJPEGSegments(plainJPEG, jpegSegment(0xE1, gpsExif), jpegSegment(0xE1, xmp)), plainJPEG},
+		{"jpeg keeps other segments", "image/jpeg",
+			withJPEGSegments(plainJPEG, comment, jpegSegment(0xE1, gpsExif)), withJPEGSegments(plainJPEG, comment)},
+		{"png without metadata", "image/png", plainPNG, plainPNG},
+		{"png eXIf", "image/png", withPNGChunks(plainPNG, pngChunk("eXIf", gpsExif[6:])), plainPNG},
+		{"png text chunks", "image/png", withPNGChunks(plainPNG,
+			pngChunk("tEXt", []byte("GPS\x0013.7563,100.5018")),
+			pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...)),
+		), plainPNG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripImageMetadata(tt.in, tt.contentType)
+			if err != nil {
+				t.Fatalf("stripImageMetadata: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("got %d bytes, want %d bytes", len(got), len(tt.want))
+			}
+			if bytes.Contains(got, []byte("GPS")) {
+				t.Fatal("output still contains GPS metadata")
+			}
+			if _, _, err := image.Decode(bytes.NewReader(got)); err != nil {
+				t.Fatalf("output does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripImageMetadataRejectsMalformed(t *testing.T) {
+	plainJPEG := encodeTestJPEG(t)
+	plainPNG := encodeTestPNG(t)
+
+	tests := []struct {
+		name        string
+		contentType string
+		in          []byte
+	}{
+		{"empty jpeg", "image/jpeg", nil},
+		{"png sent as jpeg", "image/jpeg", plainPNG},
+		{"jpeg segment past the end", "image/jpeg", append([]byte{0xFF, 0xD8}, 0xFF, 0xE1, 0xFF, 0xFF, 0x00)},
+		{"jpeg truncated before scan", "image/jpeg", plainJPEG[:20]},
+		{"jpeg sent as png", "image/png", plainJPEG},
+		{"png without IEND", "image/png", plainPNG[:len(plainPNG)-12]},
+		{"png chunk past the end", "image/png", plainPNG[:len(plainPNG)-4]},
+		{"other type", "image/gif", plainPNG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := stripImageMetadata(tt.in, tt.contentType); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
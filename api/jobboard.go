@@ -0,0 +1,238 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"delivery_webservice/auth"
+	"delivery_webservice/config"
+)
+
+// JobStop คือจุดรับหรือจุดส่งของงานที่ไรเดอร์เห็นก่อนรับงาน ไม่มีเบอร์โทรศัพท์ของผู้ส่งและผู้รับ
+type JobStop struct {
+	Address    string  `json:"address"`
+	Location   *LatLng `json:"location"`
+	RiderNotes string  `json:"rider_notes,omitempty"`
+}
+
+// Job คืองานที่รอไรเดอร์บนกระดานงาน
+type Job struct {
+	ShipmentID   int       `json:"shipment_id"`
+	DistanceM    int       `json:"distance_m"` // ระยะจากไรเดอร์ถึงจุดรับ เป็นเมตร
+	ItemCount    int       `json:"item_count"`
+	Pickup       JobStop   `json:"pickup"`
+	Dropoff      JobStop   `json:"dropoff"`
+	WaitingSince time.Time `json:"waiting_since"`
+}
+
+// postJob ลงการจัดส่งที่เพิ่งเป็นสถานะรอไรเดอร์บนกระดานงาน การจัดส่งที่ไม่มีพิกัดจุดรับจะไม่ถูกลง
+func postJob(tx *sql.Tx, shipmentID int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO Job_Board (shipment_id, pickup_location, created_at)
+		SELECT shipments, sender_gps_location, UTC_TIMESTAMP()
+		FROM Shipments WHERE shipments = ? AND sender_gps_location IS NOT NULL`,
+		shipmentID,
+	)
+	return err
+}
+
+// removeJob เอาการจัดส่งที่ไม่ได้รอไรเดอร์แล้วออกจากกระดานงาน
+func removeJob(tx *sql.Tx, shipmentID int64) error {
+	_, err := tx.Exec("DELETE FROM Job_Board WHERE shipment_id = ?", shipmentID)
+	return err
+}
+
+// searchBox คืนสี่เหลี่ยมที่ครอบวงกลมรัศมี radiusM รอบ center เป็น WKT แบบ longitude-latitude
+// ใช้กรองด้วย spatial index ก่อนคำนวณระยะจริง
+func searchBox(center LatLng, radiusM float64) string {
+	dLat := radiusM / 111320
+	dLng := radiusM / (111320 * math.Cos(center.Lat*math.Pi/180))
+	minLat, maxLat := center.Lat-dLat, center.Lat+dLat
+	minLng, maxLng := center.Lng-dLng, center.Lng+dLng
+	return fmt.Sprintf("POLYGON((%f %f, %f %f, %f %f, %f %f, %f %f))",
+		minLng, minLat, maxLng, minLat, maxLng, maxLat, minLng, maxLat, minLng, minLat)
+}
+
+// ListJobs คืนงานที่รอไรเดอร์ใกล้ตำแหน่งปัจจุบันของไรเดอร์ เรียงจากใกล้ไปไกล
+// Query parameters: lat, lng (จำเป็น), radius_km, limit, offset
+func ListJobs(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		params := r.URL.Query()
+
+		fields := map[string]string{}
+		var position LatLng
+		var err error
+		if position.Lat, err = strconv.ParseFloat(params.Get("lat"), 64); err != nil {
+			fields["lat"] = "must be a number"
+		}
+		if position.Lng, err = strconv.ParseFloat(params.Get("lng"), 64); err != nil {
+			fields["lng"] = "must be a number"
+		}
+		if len(fields) == 0 {
+			if msg := position.validate(); msg != "" {
+				fields["lat"], fields["lng"] = msg, msg
+			}
+		}
+		radiusKm := config.JobBoardRadiusKm
+		if v := params.Get("radius_km"); v != "" {
+			radiusKm, err = strconv.ParseFloat(v, 64)
+			if err != nil || radiusKm <= 0 || radiusKm > config.JobBoardMaxRadiusKm {
+				fields["radius_km"] = fmt.Sprintf("must be more than 0 and at most %g", config.JobBoardMaxRadiusKm)
+			}
+		}
+		if len(fields) > 0 {
+			writeFieldErrors(w, fields)
+			return
+		}
+
+		limit := 20
+		if v, err := strconv.Atoi(params.Get("limit")); err == nil && v > 0 && v <= 100 {
+			limit = v
+		}
+		offset := 0
+		if v, err := strconv.Atoi(params.Get("offset")); err == nil && v > 0 {
+			offset = v
+		}
+
+		// ไรเดอร์ที่ยังไม่ผ่านการตรวจเอกสารยังไม่เห็นที่อยู่ของลูกค้า
+		approved, err := riderApproved(db, caller.ID)
+		if err != nil {
+			log.Println("Error checking rider verification:", err)
+			writeError(w, "Failed to retrieve jobs", http.StatusInternalServerError)
+			return
+		}
+		if !approved {
+			writeErrorCode(w, "Rider has not been approved to take jobs", http.StatusForbidden, codeRiderNotApproved)
+			return
+		}
+
+		radiusM := radiusKm * 1000
+		rows, err := db.Query(`
+			SELECT s.shipments, ST_Distance_Sphere(j.pickup_location, `+pointFromText+`),
+				(SELECT COUNT(*) FROM Shipment_Items WHERE shipment_id = s.shipments),
+				COALESCE(s.sender_address, ''), `+latLngColumns("s.sender_gps_location")+`, COALESCE(s.pickup_notes, ''),
+				COALESCE(s.receiver_address, ''), `+latLngColumns("s.receiver_gps_location")+`, COALESCE(s.dropoff_notes, ''),
+				j.created_at
+			FROM Job_Board j
+			JOIN Shipments s ON s.shipments = j.shipment_id
+			WHERE MBRContains(ST_GeomFromText(?, 4326, 'axis-order=long-lat'), j.pickup_location)
+				AND s.status = ?
+				AND ST_Distance_Sphere(j.pickup_location, `+pointFromText+`) <= ?
+			ORDER BY 2, s.shipments
+			LIMIT ? OFFSET ?`,
+			position.wkt(), searchBox(position, radiusM), shipmentStatusWaiting, position.wkt(), radiusM, limit, offset,
+		)
+		if err != nil {
+			log.Println("Error listing jobs:", err)
+			writeError(w, "Failed to retrieve jobs", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		jobs := []Job{}
+		for rows.Next() {
+			var job Job
+			var distance float64
+			var pickup, dropoff nullLatLng
+			err := rows.Scan(&job.ShipmentID, &distance, &job.ItemCount,
+				&job.Pickup.Address, &pickup.Lat, &pickup.Lng, &job.Pickup.RiderNotes,
+				&job.Dropoff.Address, &dropoff.Lat, &dropoff.Lng, &job.Dropoff.RiderNotes,
+				&job.WaitingSince)
+			if err != nil {
+				log.Println("Error scanning job:", err)
+				writeError(w, "Failed to retrieve jobs", http.StatusInternalServerError)
+				return
+			}
+			job.DistanceM = int(math.Round(distance))
+			job.Pickup.Location = pickup.ptr()
+			job.Dropoff.Location = dropoff.ptr()
+			jobs = append(jobs, job)
+		}
+		if err := rows.Err(); err != nil {
+			log.Println("Error listing jobs:", err)
+			writeError(w, "Failed to retrieve jobs", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"radius_km": radiusKm,
+			"limit":     limit,
+			"offset":    offset,
+			"jobs":      jobs,
+		})
+	}
+}
+
+// checkActiveJobLimit ตรวจว่าไรเดอร์ยังรับงานเพิ่มได้ภายใต้ config.RiderMaxActiveJobs
+// แถวของไรเดอร์ถูกล็อกไว้จนจบ transaction ไรเดอร์คนเดียวกดรับหลายงานพร้อมกันจึงนับได้ถูกต้อง
+func checkActiveJobLimit(tx *sql.Tx, riderID int) error {
+	var locked int
+	if err := tx.QueryRow("SELECT rid FROM Riders WHERE rid = ? FOR UPDATE", riderID).Scan(&locked); err != nil {
+		return err
+	}
+	active, err := riderActiveJobs(tx, riderID)
+	if err != nil {
+		return err
+	}
+	if active >= config.RiderMaxActiveJobs {
+		return errActiveJobLimit
+	}
+	return nil
+}
+
+// riderActiveJobs นับงานที่ไรเดอร์ถืออยู่ ตั้งแต่รับงานจนกว่าจะส่งสำเร็จ ยกเลิก หรือส่งไม่สำเร็จ
+func riderActiveJobs(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, riderID int) (int, error) {
+	var active int
+	err := q.QueryRow(
+		"SELECT COUNT(*) FROM Shipments WHERE rider_id = ? AND status IN ("+shipmentStatusList(activeShipmentStatuses)+")",
+		riderID,
+	).Scan(&active)
+	return active, err
+}
+
+// acceptShipment ให้ไรเดอร์รับงานที่รออยู่ เมื่อหลายคนกดรับพร้อมกันจะมีเพียงคนเดียวที่ได้งาน
+// คนอื่นได้ errShipmentTaken
+func acceptShipment(db *sql.DB, shipmentID int, rider auth.Identity) error {
+	_, err := transitionShipment(db, shipmentID, shipmentStatusRiderAssigned, rider, "", nil)
+	return err
+}
+
+// AcceptJob ให้ไรเดอร์รับงานจากกระดานงาน ตอบ 409 shipment_taken เมื่อมีคนรับไปก่อน
+// และ 409 active_job_limit เมื่อไรเดอร์ถืองานครบจำนวนแล้ว
+func AcceptJob(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		shipmentID, ok := shipmentIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		if err := acceptShipment(db, shipmentID, caller); err != nil {
+			writeTransitionError(w, err, "Error accepting shipment")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":     "Shipment accepted",
+			"shipment_id": shipmentID,
+			"status":      shipmentStatusRiderAssigned,
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+
+	"delivery_webservice/auth"
+	"delivery_webservice/config"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// openTestDB connects to the database named by TEST_MYSQL_DSN, which must have every migration
+// applied. Tests that need it are skipped when the variable is not set.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TEST_MYSQL_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// seedAccount inserts an active account with a phone number no other test run uses
+func seedAccount(t *testing.T, db *sql.DB) (int, string) {
+	t.Helper()
+	phone := fmt.Sprintf("+66990%07d", rand.Intn(10000000))
+	result, err := db.Exec(
+		"INSERT INTO Accounts (phone_number, password, status) VALUES (?, '', ?)", phone, accountStatusActive,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM Accounts WHERE account_id = ?", id) })
+	return int(id), phone
+}
+
+// seedApprovedRider inserts a rider who has passed document verification
+func seedApprovedRider(t *testing.T, db *sql.DB) auth.Identity {
+	t.Helper()
+	accountID, phone := seedAccount(t, db)
+	result, err := db.Exec(`
+		INSERT INTO Riders (account_id, phone_number, name, profile_image, license_plate, verification_status, verified_at)
+		VALUES (?, ?, 'Test rider', '', 'TEST', ?, UTC_TIMESTAMP())`,
+		accountID, phone, riderVerificationApproved,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rid, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cleanups run last-registered first, so this runs before the account is deleted
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM Shipment_Status_History WHERE shipment_id IN (SELECT shipments FROM Shipments WHERE rider_id = ?)", rid)
+		db.Exec("DELETE FROM Shipments WHERE rider_id = ?", rid)
+		db.Exec("DELETE FROM Riders WHERE rid = ?", rid)
+	})
+	return auth.Identity{AccountID: accountID, ID: int(rid), Role: auth.RoleRider}
+}
+
+// seedWaitingShipment inserts a user and a shipment of theirs that is waiting for a rider
+func seedWaitingShipment(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	accountID, phone := seedAccount(t, db)
+	result, err := db.Exec(
+		"INSERT INTO Users (account_id, phone_number, name, profile_image, address) VALUES (?, ?, 'Test sender', '', 'Test address')",
+		accountID, phone,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM Users WHERE uid = ?", uid) })
+
+	result, err = db.Exec(`
+		INSERT INTO Shipments (sender_id, receiver_id, status, status_updated_at, sender_name, sender_phone, sender_address)
+		VALUES (?, ?, ?, UTC_TIMESTAMP(), 'Test sender', ?, 'Test address')`,
+		uid, uid, shipmentStatusWaiting, phone,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM Shipment_Status_History WHERE shipment_id = ?", id)
+		db.Exec("DELETE FROM Job_Board WHERE shipment_id = ?", id)
+		db.Exec("DELETE FROM Shipments WHERE shipments = ?", id)
+	})
+	return int(id)
+}
+
+// acceptConcurrently has riders[i] accept shipmentIDs[i], all at the same moment, and returns
+// the result of each accept
+func acceptConcurrently(db *sql.DB, shipmentIDs []int, riders []auth.Identity) []error {
+	// Every goroutine waits on start so the accepts reach the database together
+	start := make(chan struct{})
+	errs := make([]error, len(riders))
+	var wg sync.WaitGroup
+	for i, rider := range riders {
+		wg.Add(1)
+		go func(i int, rider auth.Identity) {
+			defer wg.Done()
+			<-start
+			errs[i] = acceptShipment(db, shipmentIDs[i], rider)
+		}(i, rider)
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+func TestAcceptShipmentConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	const riderCount = 20
+
+	shipmentID := seedWaitingShipment(t, db)
+	riders := make([]auth.Identity, riderCount)
+	for i := range riders {
+		riders[i] = seedApprovedRider(t, db)
+	}
+
+	shipmentIDs := make([]int, riderCount)
+	for i := range shipmentIDs {
+		shipmentIDs[i] = shipmentID
+	}
+	errs := acceptConcurrently(db, shipmentIDs, riders)
+
+	accepted, taken := 0, 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			accepted++
+		case errors.Is(err, errShipmentTaken):
+			taken++
+		default:
+			t.Errorf("rider %d: unexpected error: %v", riders[i].ID, err)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("accepted = %d, want 1", accepted)
+	}
+	if taken != riderCount-1 {
+		t.Errorf("taken = %d, want %d", taken, riderCount-1)
+	}
+
+	for _, rider := range riders {
+		active, err := riderActiveJobs(db, rider.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active > config.RiderMaxActiveJobs {
+			t.Errorf("rider %d holds %d active jobs, limit is %d", rider.ID, active, config.RiderMaxActiveJobs)
+		}
+	}
+}
+
+func TestAcceptShipmentActiveJobLimit(t *testing.T) {
+	db := openTestDB(t)
+	// Allow more than one job so the limit is reached part-way through the burst
+	defer func(limit int) { config.RiderMaxActiveJobs = limit }(config.RiderMaxActiveJobs)
+	config.RiderMaxActiveJobs = 3
+	const extra = 5
+	attempts := config.RiderMaxActiveJobs + extra
+
+	shipmentIDs := make([]int, attempts)
+	for i := range shipmentIDs {
+		shipmentIDs[i] = seedWaitingShipment(t, db)
+	}
+	rider := seedApprovedRider(t, db)
+	riders := make([]auth.Identity, attempts)
+	for i := range riders {
+		riders[i] = rider
+	}
+
+	accepted, limited := 0, 0
+	for i, err := range acceptConcurrently(db, shipmentIDs, riders) {
+		switch {
+		case err == nil:
+			accepted++
+		case errors.Is(err, errActiveJobLimit):
+			limited++
+		default:
+			t.Errorf("shipment %d: unexpected error: %v", shipmentIDs[i], err)
+		}
+	}
+	if accepted != config.RiderMaxActiveJobs {
+		t.Errorf("accepted = %d, want %d", accepted, config.RiderMaxActiveJobs)
+	}
+	if limited != extra {
+		t.Errorf("limited = %d, want %d", limited, extra)
+	}
+
+	active, err := riderActiveJobs(db, rider.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active != config.RiderMaxActiveJobs {
+		t.Errorf("rider holds %d active jobs, want %d", active, config.RiderMaxActiveJobs)
+	}
+}
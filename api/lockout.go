@@ -0,0 +1,76 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LoginLockout is a lockout event recorded in Login_Lockouts
+type LoginLockout struct {
+	ID          int       `json:"id"`
+	KeyType     string    `json:"key_type"`
+	KeyValue    string    `json:"key_value"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// recordLockout stores a lockout event so support staff can look it up later
+func recordLockout(db *sql.DB, keyType, keyValue string, failures int, lockedUntil time.Time) {
+	log.Printf("Login locked for %s %s after %d failures until %s", keyType, keyValue, failures, lockedUntil.Format(time.RFC3339))
+	_, err := db.Exec(
+		"INSERT INTO Login_Lockouts (key_type, key_value, failures, locked_until, created_at) VALUES (?, ?, ?, ?, ?)",
+		keyType, keyValue, failures, lockedUntil.UTC(), time.Now().UTC(),
+	)
+	if err != nil {
+		log.Println("Error recording login lockout:", err)
+	}
+}
+
+// ListLoginLockouts returns recent lockouts, optionally filtered by ?phone= or ?ip=
+func ListLoginLockouts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := "SELECT id, key_type, key_value, failures, locked_until, created_at FROM Login_Lockouts"
+		var args []interface{}
+		if phone := trimSpace(r.URL.Query().Get("phone")); phone != "" {
+			query += " WHERE key_type = 'phone' AND key_value = ?"
+			args = append(args, phone)
+		} else if ip := trimSpace(r.URL.Query().Get("ip")); ip != "" {
+			query += " WHERE key_type = 'ip' AND key_value = ?"
+			args = append(args, ip)
+		}
+
+		limit := 100
+		if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 500 {
+			limit = v
+		}
+		query += " ORDER BY id DESC LIMIT ?"
+		args = append(args, limit)
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			log.Println("Error listing login lockouts:", err)
+			writeError(w, "Failed to retrieve lockouts", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		lockouts := []LoginLockout{}
+		for rows.Next() {
+			var l LoginLockout
+			if err := rows.Scan(&l.ID, &l.KeyType, &l.KeyValue, &l.Failures, &l.LockedUntil, &l.CreatedAt); err != nil {
+				log.Println("Error scanning login lockout:", err)
+				writeError(w, "Failed to retrieve lockouts", http.StatusInternalServerError)
+				return
+			}
+			lockouts = append(lockouts, l)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lockouts)
+	}
+}
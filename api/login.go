@@ -3,9 +3,12 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strings"
 
+	"delivery_webservice/auth"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -13,80 +16,214 @@ import (
 type LoginRequest struct {
 	PhoneNumber string `json:"phone_number"`
 	Password    string `json:"password"`
+	Role        string `json:"role,omitempty"` // optional role to act as when the account has several
+	DeviceName  string `json:"device_name,omitempty"`
+	Platform    string `json:"platform,omitempty"` // e.g. "android", "ios", "web"
+}
+
+// validate trims the fields and puts the phone number in the form it is stored in
+func (req *LoginRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.PhoneNumber = lookupPhone(req.PhoneNumber)
+	req.Password = strings.TrimSpace(req.Password)
+	req.Role = strings.TrimSpace(req.Role)
+	requireField(fields, "phone_number", req.PhoneNumber)
+	requireField(fields, "password", req.Password)
+	if req.Role != "" && !auth.ValidRole(req.Role) {
+		fields["role"] = "is not a known role"
+	}
+	return fields
 }
 
-// LoginUserOrRider handles login for both users and riders
+// LoginUserOrRider handles login for users, riders and admins; one account may hold several roles
 func LoginUserOrRider(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req LoginRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid input", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
-		// Trim spaces and check for empty fields
-		req.PhoneNumber = strings.TrimSpace(req.PhoneNumber)
-		req.Password = strings.TrimSpace(req.Password)
-		if req.PhoneNumber == "" || req.Password == "" {
-			http.Error(w, "Phone number and password cannot be empty", http.StatusBadRequest)
+		// Reserve the attempt before doing any bcrypt work, so throttled phones and IPs and bursts
+		// of parallel guesses are turned away cheaply
+		ip := clientIP(r)
+		if wait := reserveLogin(req.PhoneNumber, ip); wait > 0 {
+			recordAudit(db, r, auditLoginFailure, 0, 0, map[string]interface{}{"phone_hash": auditPhone(req.PhoneNumber), "reason": "throttled"})
+			writeRetryAfter(w, wait, "Too many failed login attempts, please try again later")
 			return
 		}
+		defer releaseLogin(req.PhoneNumber, ip)
 
-		// Check if the user or rider exists and validate the password
-		isRider, id, hashedPassword, err := getUserOrRiderDetails(db, req.PhoneNumber)
+		// Check if the account exists and validate the password
+		account, err := getAccountByPhone(db, req.PhoneNumber)
 		if err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			log.Println("Error looking up account:", err)
+			writeError(w, "Error logging in", http.StatusInternalServerError)
+			return
+		}
+
+		// Unknown phones are checked against a dummy hash so they take as long as a wrong password
+		passwordHash := account.PasswordHash
+		if passwordHash == "" {
+			passwordHash = dummyPasswordHash()
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil || account.AccountID == 0 {
+			loginFailed(db, req.PhoneNumber, ip)
+			reason := "bad_password"
+			if account.AccountID == 0 {
+				reason = "unknown_account"
+			}
+			recordAudit(db, r, auditLoginFailure, account.AccountID, 0, map[string]interface{}{"phone_hash": auditPhone(req.PhoneNumber), "reason": reason})
+			writeErrorCode(w, "Invalid credentials", http.StatusUnauthorized, codeInvalidCredentials)
+			return
+		}
+		loginSucceeded(req.PhoneNumber)
+
+		// Accounts must verify their phone number before they can log in
+		if account.Status == accountStatusPending {
+			recordAudit(db, r, auditLoginFailure, account.AccountID, 0, map[string]interface{}{"reason": "pending"})
+			writeErrorCode(w, "Phone number has not been verified", http.StatusForbidden, codePhoneNotVerified)
+			return
+		}
+
+		// Suspended accounts cannot log in until an admin reinstates them
+		if account.Suspended {
+			recordAudit(db, r, auditLoginFailure, account.AccountID, 0, map[string]interface{}{"reason": "suspended"})
+			writeErrorCode(w, "Account suspended", http.StatusForbidden, codeAccountSuspended)
 			return
 		}
 
-		// Compare the provided password with the stored hashed password
-		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)); err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		// Act as the requested role, or the first one the account holds
+		if len(account.Roles) == 0 {
+			writeForbidden(w)
+			return
+		}
+		userType := account.Roles[0].Role
+		if req.Role != "" {
+			userType = req.Role
+		}
+		id, ok := account.roleID(userType)
+		if !ok {
+			writeForbidden(w)
 			return
 		}
 
-		// Determine the user type ("rider" or "user")
-		userType := "user"
-		if isRider {
-			userType = "rider"
+		// Upgrade hashes made with an old bcrypt cost while we still have the plain password
+		if needsRehash(account.PasswordHash) {
+			if hashedPassword, err := hashPassword(req.Password); err != nil {
+				log.Println("Error rehashing password:", err)
+			} else if err := updatePassword(db, account.AccountID, hashedPassword); err != nil {
+				log.Println("Error storing rehashed password:", err)
+			}
 		}
 
-		// Sending back ID and type information in response
+		// Start a new session and issue a signed access token carrying the ID and role
+		sessionID, refreshToken, refreshExpiresAt, err := createSession(db, account.AccountID, userType, deviceInfo{
+			Name:      trimSpace(req.DeviceName),
+			Platform:  trimSpace(req.Platform),
+			IP:        ip,
+			UserAgent: r.UserAgent(),
+		})
+		if err != nil {
+			log.Println("Error creating session:", err)
+			writeError(w, "Error creating session", http.StatusInternalServerError)
+			return
+		}
+
+		identity := auth.Identity{AccountID: account.AccountID, ID: id, Role: userType, SessionID: sessionID}
+		accessToken, expiresAt, err := auth.IssueAccessToken(identity)
+		if err != nil {
+			log.Println("Error issuing access token:", err)
+			writeError(w, "Error issuing access token", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, auditLoginSuccess, account.AccountID, 0, map[string]interface{}{"role": userType, "session_id": sessionID})
+
+		// Sending back ID, type, every available role and token information in response
 		response := map[string]interface{}{
-			"message": "Login successful",
-			"id":      id,      // Either "uid" or "rid"
-			"type":    userType, // Either "rider" or "user"
+			"message":            "Login successful",
+			"account_id":         account.AccountID,
+			"id":                 id,       // "uid", "rid" or admin id of the active role
+			"type":               userType, // active role: "rider", "user" or "admin"
+			"roles":              account.Roles,
+			"access_token":       accessToken,
+			"token_type":         "Bearer",
+			"expires_at":         expiresAt.Unix(),
+			"refresh_token":      refreshToken,
+			"refresh_expires_at": refreshExpiresAt.Unix(),
 		}
 
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK) // Set the HTTP status code to 200 OK
 		json.NewEncoder(w).Encode(response)
 	}
 }
 
-// getUserOrRiderDetails retrieves the correct ID, hashed password, and user type for a given phone number
-func getUserOrRiderDetails(db *sql.DB, phone string) (bool, int, string, error) {
-	var hashedPassword string
-	var id int
-
-	// Check in Riders table
-	query := "SELECT rid, password FROM Riders WHERE phone_number = ?"
-	err := db.QueryRow(query, phone).Scan(&id, &hashedPassword)
-	if err == nil {
-		return true, id, hashedPassword, nil // Found in Riders
-	} else if err != sql.ErrNoRows {
-		// Handle unexpected error
-		return false, 0, "", err 
-	}
+// SwitchRoleRequest selects which of the account's roles the session acts as
+type SwitchRoleRequest struct {
+	Role string `json:"role"`
+}
 
-	// Check in Users table
-	query = "SELECT uid, password FROM Users WHERE phone_number = ?"
-	err = db.QueryRow(query, phone).Scan(&id, &hashedPassword)
-	if err == nil {
-		return false, id, hashedPassword, nil // Found in Users
-	} else if err != sql.ErrNoRows {
-		// Handle unexpected error
-		return false, 0, "", err
+// validate checks that the requested role exists
+func (req *SwitchRoleRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Role = strings.TrimSpace(req.Role)
+	if requireField(fields, "role", req.Role) && !auth.ValidRole(req.Role) {
+		fields["role"] = "is not a known role"
 	}
+	return fields
+}
+
+// SwitchRole changes the active role of the current session and returns a new access token for it.
+// Access tokens issued for the previous role are rejected from then on.
+func SwitchRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		var req SwitchRoleRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		roles, err := loadAccountRoles(db, caller.AccountID)
+		if err != nil {
+			log.Println("Error loading account roles:", err)
+			writeError(w, "Error switching role", http.StatusInternalServerError)
+			return
+		}
+		id, ok := accountDetails{Roles: roles}.roleID(req.Role)
+		if !ok {
+			writeForbidden(w)
+			return
+		}
+
+		if _, err := db.Exec("UPDATE Sessions SET active_role = ? WHERE sid = ?", req.Role, caller.SessionID); err != nil {
+			log.Println("Error switching session role:", err)
+			writeError(w, "Error switching role", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, auditRoleSwitch, caller.AccountID, 0, map[string]interface{}{"from": caller.Role, "to": req.Role})
 
-	return false, 0, "", nil // Not found
-}
\ No newline at end of file
+		identity := auth.Identity{AccountID: caller.AccountID, ID: id, Role: req.Role, SessionID: caller.SessionID}
+		accessToken, expiresAt, err := auth.IssueAccessToken(identity)
+		if err != nil {
+			log.Println("Error issuing access token:", err)
+			writeError(w, "Error issuing access token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":           id,
+			"type":         req.Role,
+			"roles":        roles,
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_at":   expiresAt.Unix(),
+		})
+	}
+}
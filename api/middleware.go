@@ -0,0 +1,122 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"delivery_webservice/auth"
+)
+
+// Authenticate rejects requests without a valid bearer token, with a revoked session or from a
+// suspended account, and stores the caller's identity in the request context. Merchant systems
+// authenticate with "Authorization: ApiKey <key>" instead of a bearer token.
+func Authenticate(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if key, found := strings.CutPrefix(header, "ApiKey "); found {
+				identity, suspended, err := authenticateAPIKey(db, strings.TrimSpace(key))
+				if errors.Is(err, errAPIKeyInvalid) {
+					writeError(w, "Invalid or revoked API key", http.StatusUnauthorized)
+					return
+				} else if err != nil {
+					log.Println("Error checking API key:", err)
+					writeError(w, "Error checking API key", http.StatusInternalServerError)
+					return
+				}
+				if suspended {
+					writeErrorCode(w, "Account suspended", http.StatusForbidden, codeAccountSuspended)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+				return
+			}
+
+			tokenString, found := strings.CutPrefix(header, "Bearer ")
+			if !found || strings.TrimSpace(tokenString) == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, "Missing access token", http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := auth.ParseAccessToken(strings.TrimSpace(tokenString))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeErrorCode(w, "Invalid or expired access token", http.StatusUnauthorized, codeInvalidToken)
+				return
+			}
+
+			active, suspended, err := checkSession(db, claims.SID, claims.Role)
+			if err != nil {
+				log.Println("Error checking session:", err)
+				writeError(w, "Error checking session", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeErrorCode(w, "Session has been revoked or switched role", http.StatusUnauthorized, codeInvalidToken)
+				return
+			}
+			if suspended {
+				writeErrorCode(w, "Account suspended", http.StatusForbidden, codeAccountSuspended)
+				return
+			}
+
+			touchSession(db, claims.SID, clientIP(r))
+
+			ctx := auth.WithIdentity(r.Context(), claims.Identity())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// currentIdentity returns the caller set by Authenticate; handlers behind the middleware can rely on it
+func currentIdentity(w http.ResponseWriter, r *http.Request) (auth.Identity, bool) {
+	id, ok := auth.FromContext(r.Context())
+	if !ok {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+	}
+	return id, ok
+}
+
+// Access declares who may call a route. Callers need one of Roles; API key callers are
+// only let through when Scope is set and their key was granted it.
+type Access struct {
+	Roles []string
+	Scope string
+}
+
+// RequireAccess enforces an Access rule; it must run after Authenticate
+func RequireAccess(access Access) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, ok := currentIdentity(w, r)
+			if !ok {
+				return
+			}
+			if !slices.Contains(access.Roles, caller.Role) {
+				writeForbidden(w)
+				return
+			}
+			if caller.APIKeyID != 0 && (access.Scope == "" || !caller.HasScope(access.Scope)) {
+				writeForbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole only lets logged-in callers with one of the given roles through; API keys are refused
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return RequireAccess(Access{Roles: roles})
+}
+
+// writeForbidden is the single response used for every authorization failure
+func writeForbidden(w http.ResponseWriter) {
+	writeError(w, "Forbidden", http.StatusForbidden)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"delivery_webservice/config"
+	"delivery_webservice/notify"
+)
+
+// OTP purposes stored in Otp_Codes.purpose
+const (
+	otpPurposePasswordReset = "password_reset"
+	otpPurposeVerifyPhone   = "verify_phone"
+)
+
+var errOTPInvalid = errors.New("code is invalid or expired")
+
+// otpRateLimitError is returned when a phone asks for codes too often
+type otpRateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *otpRateLimitError) Error() string {
+	return fmt.Sprintf("too many codes requested, retry after %s", e.RetryAfter)
+}
+
+// generateOTP returns a random 6-digit numeric code
+func generateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashOTP binds a code to its phone and purpose with the server secret
+func hashOTP(phone, purpose, code string) string {
+	mac := hmac.New(sha256.New, config.JWTSecret)
+	mac.Write([]byte(purpose + "|" + phone + "|" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issueOTP creates a new code for phone, replacing any earlier one, and sends it by SMS
+func issueOTP(ctx context.Context, db *sql.DB, sender notify.SMSSender, phone, purpose, message string) error {
+	var sentLastHour int
+	var lastSent sql.NullTime
+	err := db.QueryRow(`
+		SELECT COUNT(*), MAX(created_at)
+		FROM Otp_Codes
+		WHERE phone_number = ? AND purpose = ? AND created_at > UTC_TIMESTAMP() - INTERVAL 1 HOUR`,
+		phone, purpose,
+	).Scan(&sentLastHour, &lastSent)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if lastSent.Valid {
+		if wait := lastSent.Time.Add(config.OTPResendInterval).Sub(now); wait > 0 {
+			return &otpRateLimitError{RetryAfter: wait}
+		}
+	}
+	if sentLastHour >= config.OTPMaxPerHour {
+		return &otpRateLimitError{RetryAfter: time.Hour}
+	}
+
+	code, err := generateOTP()
+	if err != nil {
+		return err
+	}
+
+	// ยกเลิกรหัสเก่าที่ยังไม่ได้ใช้ ให้ใช้ได้เฉพาะรหัสล่าสุด
+	_, err = db.Exec(
+		"UPDATE Otp_Codes SET consumed_at = UTC_TIMESTAMP() WHERE phone_number = ? AND purpose = ? AND consumed_at IS NULL",
+		phone, purpose,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO Otp_Codes (phone_number, purpose, code_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		phone, purpose, hashOTP(phone, purpose, code), now.Add(config.OTPTTL), now,
+	)
+	if err != nil {
+		return err
+	}
+
+	return sender.Send(ctx, phone, fmt.Sprintf(message, code))
+}
+
+// verifyOTP checks code against the latest unused code for phone and consumes it on success.
+// Every wrong guess counts against the code, which stops working after OTPMaxAttempts.
+func verifyOTP(db *sql.DB, phone, purpose, code string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id, attempts int
+	var codeHash string
+	var expiresAt time.Time
+	err = tx.QueryRow(`
+		SELECT id, code_hash, attempts, expires_at
+		FROM Otp_Codes
+		WHERE phone_number = ? AND purpose = ? AND consumed_at IS NULL
+		ORDER BY id DESC
+		LIMIT 1
+		FOR UPDATE`,
+		phone, purpose,
+	).Scan(&id, &codeHash, &attempts, &expiresAt)
+	if err == sql.ErrNoRows {
+		return errOTPInvalid
+	} else if err != nil {
+		return err
+	}
+
+	if attempts >= config.OTPMaxAttempts || time.Now().After(expiresAt) {
+		return errOTPInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(hashOTP(phone, purpose, code))) != 1 {
+		if _, err := tx.Exec("UPDATE Otp_Codes SET attempts = attempts + 1 WHERE id = ?", id); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return errOTPInvalid
+	}
+
+	if _, err := tx.Exec("UPDATE Otp_Codes SET consumed_at = UTC_TIMESTAMP() WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeRetryAfter answers 429 with a Retry-After header in whole seconds
+func writeRetryAfter(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := int(wait.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, message, http.StatusTooManyRequests)
+}
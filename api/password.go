@@ -0,0 +1,235 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"delivery_webservice/notify"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ForgotPasswordRequest starts a password reset for a phone number
+type ForgotPasswordRequest struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+// ResetPasswordRequest sets a new password using the code sent by SMS
+type ResetPasswordRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	Code        string `json:"code"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePasswordRequest replaces the password of the logged-in account
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// validate puts the phone number in the form it is stored in
+func (req *ForgotPasswordRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.PhoneNumber = lookupPhone(req.PhoneNumber)
+	requireField(fields, "phone_number", req.PhoneNumber)
+	return fields
+}
+
+// validate trims the fields and checks the new password
+func (req *ResetPasswordRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.PhoneNumber = lookupPhone(req.PhoneNumber)
+	req.Code = trimSpace(req.Code)
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+	requireField(fields, "phone_number", req.PhoneNumber)
+	requireField(fields, "code", req.Code)
+	validatePasswordField(fields, "new_password", req.NewPassword)
+	return fields
+}
+
+// validate trims both passwords and checks the new one
+func (req *ChangePasswordRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.CurrentPassword = strings.TrimSpace(req.CurrentPassword)
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+	requireField(fields, "current_password", req.CurrentPassword)
+	validatePasswordField(fields, "new_password", req.NewPassword)
+	return fields
+}
+
+// updatePassword stores a new bcrypt hash for an account
+func updatePassword(db *sql.DB, accountID int, hashedPassword string) error {
+	_, err := db.Exec("UPDATE Accounts SET password = ? WHERE account_id = ?", hashedPassword, accountID)
+	return err
+}
+
+// getPasswordHash returns the stored bcrypt hash of an account and its phone number
+func getPasswordHash(db *sql.DB, accountID int) (string, string, error) {
+	var hashedPassword, phone string
+	err := db.QueryRow("SELECT password, phone_number FROM Accounts WHERE account_id = ?", accountID).Scan(&hashedPassword, &phone)
+	return hashedPassword, phone, err
+}
+
+// ForgotPassword sends a reset code to the phone number of a registered account
+func ForgotPassword(db *sql.DB, sms notify.SMSSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ForgotPasswordRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		// Rate limited before the lookup, so registered and unknown numbers hit the limit alike
+		if wait := resetRequests.reserve(req.PhoneNumber, time.Now()); wait > 0 {
+			writeRetryAfter(w, wait, "Too many reset requests, please try again later")
+			return
+		}
+
+		account, err := getAccountByPhone(db, req.PhoneNumber)
+		if err != nil {
+			log.Println("Error looking up account:", err)
+			writeError(w, "Error sending reset code", http.StatusInternalServerError)
+			return
+		}
+
+		// Only verified accounts get a code, but the answer is the same so callers cannot probe for accounts
+		if account.AccountID != 0 && account.Status == accountStatusActive && !account.Suspended {
+			err = issueOTP(r.Context(), db, sms, req.PhoneNumber, otpPurposePasswordReset,
+				"Your password reset code is %s. Do not share this code with anyone.")
+			var rateErr *otpRateLimitError
+			if errors.As(err, &rateErr) {
+				// Another server instance already sent codes to this number; a 429 here would
+				// only ever be seen for registered numbers, so the request is dropped silently
+				log.Println("Dropping password reset request:", err)
+			} else if err != nil {
+				log.Println("Error issuing reset code:", err)
+				writeError(w, "Error sending reset code", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If the phone number is registered, a reset code has been sent",
+		})
+	}
+}
+
+// ResetPassword replaces the password of an account after checking the SMS code,
+// and logs the account out of every device
+func ResetPassword(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResetPasswordRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		if err := verifyOTP(db, req.PhoneNumber, otpPurposePasswordReset, req.Code); errors.Is(err, errOTPInvalid) {
+			writeErrorCode(w, "Invalid or expired code", http.StatusBadRequest, codeInvalidCode)
+			return
+		} else if err != nil {
+			log.Println("Error verifying reset code:", err)
+			writeError(w, "Error resetting password", http.StatusInternalServerError)
+			return
+		}
+
+		account, err := getAccountByPhone(db, req.PhoneNumber)
+		if err != nil || account.AccountID == 0 {
+			log.Println("Error looking up account for password reset:", err)
+			writeError(w, "Error resetting password", http.StatusInternalServerError)
+			return
+		}
+
+		hashedPassword, err := hashPassword(req.NewPassword)
+		if err != nil {
+			log.Println("Error hashing password:", err)
+			writeError(w, "Error hashing password", http.StatusInternalServerError)
+			return
+		}
+
+		if err := updatePassword(db, account.AccountID, hashedPassword); err != nil {
+			log.Println("Error updating password:", err)
+			writeError(w, "Error resetting password", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := revokeAllSessions(db, account.AccountID); err != nil {
+			log.Println("Error revoking sessions after password reset:", err)
+		}
+		recordAudit(db, r, auditPasswordReset, account.AccountID, 0, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Password reset successful",
+		})
+	}
+}
+
+// ChangePassword sets a new password for the logged-in account after checking the current one.
+// Every other session of the account is logged out; the current one stays signed in.
+func ChangePassword(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		var req ChangePasswordRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		currentHash, phone, err := getPasswordHash(db, caller.AccountID)
+		if err != nil {
+			log.Println("Error fetching password:", err)
+			writeError(w, "Error changing password", http.StatusInternalServerError)
+			return
+		}
+
+		// Guesses at the current password count as failed logins, so a stolen access token
+		// cannot be used to try passwords without limit
+		ip := clientIP(r)
+		if wait := reserveLogin(phone, ip); wait > 0 {
+			writeRetryAfter(w, wait, "Too many failed password attempts, please try again later")
+			return
+		}
+		defer releaseLogin(phone, ip)
+		if err := bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(req.CurrentPassword)); err != nil {
+			loginFailed(db, phone, ip)
+			writeError(w, "Current password is incorrect", http.StatusUnauthorized)
+			return
+		}
+		loginSucceeded(phone)
+
+		hashedPassword, err := hashPassword(req.NewPassword)
+		if err != nil {
+			log.Println("Error hashing password:", err)
+			writeError(w, "Error hashing password", http.StatusInternalServerError)
+			return
+		}
+		if err := updatePassword(db, caller.AccountID, hashedPassword); err != nil {
+			log.Println("Error updating password:", err)
+			writeError(w, "Error changing password", http.StatusInternalServerError)
+			return
+		}
+
+		_, err = db.Exec(
+			"UPDATE Sessions SET revoked_at = UTC_TIMESTAMP() WHERE account_id = ? AND sid <> ? AND revoked_at IS NULL",
+			caller.AccountID, caller.SessionID,
+		)
+		if err != nil {
+			log.Println("Error revoking other sessions after password change:", err)
+		}
+		recordAudit(db, r, auditPasswordChange, caller.AccountID, 0, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Password changed successfully",
+		})
+	}
+}
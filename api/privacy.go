@@ -0,0 +1,496 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"delivery_webservice/auth"
+	"delivery_webservice/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const accountStatusDeleted = "deleted"
+
+var errAccountHasActiveShipments = errors.New("account has shipments in progress")
+
+// DataExport is everything stored about an account, as returned by ExportMyData
+type DataExport struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	Account    ExportAccount     `json:"account"`
+	User       *ExportUser       `json:"user,omitempty"`
+	Rider      *ExportRider      `json:"rider,omitempty"`
+	Addresses  []SavedAddress    `json:"saved_addresses"`
+	Shipments  []ExportShipment  `json:"shipments"`
+	Audit      []ExportAuditItem `json:"security_events"`
+}
+
+// ExportAccount is the login identity part of an export
+type ExportAccount struct {
+	AccountID   int       `json:"account_id"`
+	PhoneNumber string    `json:"phone_number"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ExportUser is the sender profile part of an export
+type ExportUser struct {
+	UID          int     `json:"uid"`
+	Name         string  `json:"name"`
+	PhoneNumber  string  `json:"phone_number"`
+	ProfileImage string  `json:"profile_image"`
+	Address      string  `json:"address"`
+	GpsLocation  *LatLng `json:"gps_location"`
+}
+
+// ExportRider is the rider profile part of an export
+type ExportRider struct {
+	RID          int    `json:"rid"`
+	Name         string `json:"name"`
+	PhoneNumber  string `json:"phone_number"`
+	ProfileImage string `json:"profile_image"`
+	LicensePlate string `json:"license_plate"`
+	VehicleType  string `json:"vehicle_type"`
+	VehicleModel string `json:"vehicle_model"`
+	VehicleColor string `json:"vehicle_color"`
+}
+
+// ExportShipment is a shipment the account took part in, with the part it played
+type ExportShipment struct {
+	ShipmentID int            `json:"shipment_id"`
+	Roles      []string       `json:"roles"` // "sender", "receiver" and/or "rider"
+	SenderID   int            `json:"sender_id"`
+	ReceiverID int            `json:"receiver_id"`
+	RiderID    *int           `json:"rider_id"`
+	Status     ShipmentStatus `json:"status"`
+	Items      []ExportItem   `json:"items"`
+}
+
+// ExportItem is an item of an exported shipment
+type ExportItem struct {
+	IID         int     `json:"iid"`
+	Description string  `json:"description"`
+	Image       *string `json:"image"`
+}
+
+// ExportAuditItem is a security event about the account
+type ExportAuditItem struct {
+	Event     string    `json:"event"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeleteAccountRequest confirms an erasure request with the account password
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// validate checks that the password was sent
+func (req *DeleteAccountRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Password = strings.TrimSpace(req.Password)
+	requireField(fields, "password", req.Password)
+	return fields
+}
+
+// buildDataExport collects everything stored about an account
+func buildDataExport(db *sql.DB, accountID int) (*DataExport, error) {
+	export := &DataExport{ExportedAt: time.Now().UTC(), Addresses: []SavedAddress{}, Shipments: []ExportShipment{}, Audit: []ExportAuditItem{}}
+
+	err := db.QueryRow(
+		"SELECT account_id, phone_number, status, created_at FROM Accounts WHERE account_id = ?",
+		accountID,
+	).Scan(&export.Account.AccountID, &export.Account.PhoneNumber, &export.Account.Status, &export.Account.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var user ExportUser
+	var location nullLatLng
+	err = db.QueryRow(`
+		SELECT uid, name, phone_number, COALESCE(profile_image, ''), COALESCE(address, ''), `+latLngColumns("gps_location")+`
+		FROM Users WHERE account_id = ?`, accountID,
+	).Scan(&user.UID, &user.Name, &user.PhoneNumber, &user.ProfileImage, &user.Address, &location.Lat, &location.Lng)
+	if err == nil {
+		user.GpsLocation = location.ptr()
+		export.User = &user
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if export.User != nil {
+		rows, err := db.Query("SELECT "+savedAddressColumns+" FROM User_Addresses WHERE uid = ? ORDER BY id", export.User.UID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			a, err := scanSavedAddress(rows)
+			if err != nil {
+				return nil, err
+			}
+			export.Addresses = append(export.Addresses, a)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var rider ExportRider
+	err = db.QueryRow(`
+		SELECT rid, name, phone_number, COALESCE(profile_image, ''), license_plate,
+			COALESCE(vehicle_type, ''), COALESCE(vehicle_model, ''), COALESCE(vehicle_color, '')
+		FROM Riders WHERE account_id = ?`, accountID,
+	).Scan(&rider.RID, &rider.Name, &rider.PhoneNumber, &rider.ProfileImage, &rider.LicensePlate,
+		&rider.VehicleType, &rider.VehicleModel, &rider.VehicleColor)
+	if err == nil {
+		export.Rider = &rider
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if export.User != nil || export.Rider != nil {
+		uid, rid := 0, 0
+		if export.User != nil {
+			uid = export.User.UID
+		}
+		if export.Rider != nil {
+			rid = export.Rider.RID
+		}
+		if export.Shipments, err = exportShipments(db, uid, rid); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.Query(
+		"SELECT event, ip, user_agent, created_at FROM Audit_Log WHERE account_id = ? ORDER BY id",
+		accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item ExportAuditItem
+		if err := rows.Scan(&item.Event, &item.IP, &item.UserAgent, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.Audit = append(export.Audit, item)
+	}
+	return export, rows.Err()
+}
+
+// exportShipments returns the shipments sent or received by uid, or carried by rid, with their items
+func exportShipments(db *sql.DB, uid, rid int) ([]ExportShipment, error) {
+	rows, err := db.Query(`
+		SELECT s.shipments, s.sender_id, s.receiver_id, s.rider_id, s.status, si.iid, si.description, si.image
+		FROM Shipments s
+		LEFT JOIN Shipment_Items si ON si.shipment_id = s.shipments
+		WHERE s.sender_id = ? OR s.receiver_id = ? OR s.rider_id = ?
+		ORDER BY s.shipments, si.iid`,
+		uid, uid, rid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shipments := []ExportShipment{}
+	for rows.Next() {
+		var shipment ExportShipment
+		var riderID, itemID sql.NullInt64
+		var description, image sql.NullString
+		err := rows.Scan(&shipment.ShipmentID, &shipment.SenderID, &shipment.ReceiverID, &riderID, &shipment.Status,
+			&itemID, &description, &image)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(shipments); n == 0 || shipments[n-1].ShipmentID != shipment.ShipmentID {
+			if riderID.Valid {
+				id := int(riderID.Int64)
+				shipment.RiderID = &id
+			}
+			if uid != 0 && shipment.SenderID == uid {
+				shipment.Roles = append(shipment.Roles, "sender")
+			}
+			if uid != 0 && shipment.ReceiverID == uid {
+				shipment.Roles = append(shipment.Roles, "receiver")
+			}
+			if rid != 0 && shipment.RiderID != nil && *shipment.RiderID == rid {
+				shipment.Roles = append(shipment.Roles, "rider")
+			}
+			shipment.Items = []ExportItem{}
+			shipments = append(shipments, shipment)
+		}
+
+		if itemID.Valid {
+			item := ExportItem{IID: int(itemID.Int64), Description: description.String}
+			if image.Valid {
+				item.Image = &image.String
+			}
+			last := &shipments[len(shipments)-1]
+			last.Items = append(last.Items, item)
+		}
+	}
+	return shipments, rows.Err()
+}
+
+// ExportMyData returns a JSON archive of everything stored about the logged-in account
+func ExportMyData(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		export, err := buildDataExport(db, caller.AccountID)
+		if err != nil {
+			log.Println("Error building data export:", err)
+			writeError(w, "Failed to export data", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, auditDataExport, caller.AccountID, 0, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d-export.json"`, caller.AccountID))
+		json.NewEncoder(w).Encode(export)
+	}
+}
+
+// DeleteMyAccount erases the logged-in account. Profiles are anonymized rather than deleted
+// so that shipments of other people keep pointing at valid rows. The images the account uploaded,
+// which include its profile and item photos, the proof photos of its shipments and the rider's
+// verification documents are deleted from storage. Shipments still waiting for a rider are
+// cancelled; while a rider is on one of the account's shipments the request fails with 409.
+func DeleteMyAccount(db *sql.DB, store storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		var req DeleteAccountRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		hashedPassword, _, err := getPasswordHash(db, caller.AccountID)
+		if err != nil {
+			log.Println("Error fetching password:", err)
+			writeError(w, "Error deleting account", http.StatusInternalServerError)
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)) != nil {
+			writeError(w, "Password is incorrect", http.StatusUnauthorized)
+			return
+		}
+
+		roles, err := loadAccountRoles(db, caller.AccountID)
+		if err != nil {
+			log.Println("Error loading account roles:", err)
+			writeError(w, "Error deleting account", http.StatusInternalServerError)
+			return
+		}
+		if _, isAdmin := (accountDetails{Roles: roles}).roleID(auth.RoleAdmin); isAdmin {
+			writeError(w, "Admin accounts must be removed by another admin", http.StatusConflict)
+			return
+		}
+
+		objectKeys, err := eraseAccount(db, caller.AccountID)
+		if errors.Is(err, errAccountHasActiveShipments) {
+			writeError(w, "Account has shipments in progress; wait until they are delivered", http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Println("Error erasing account:", err)
+			writeError(w, "Error deleting account", http.StatusInternalServerError)
+			return
+		}
+		for _, key := range objectKeys {
+			if err := store.Delete(r.Context(), key); err != nil {
+				log.Println("Error deleting erased file:", err)
+			}
+		}
+		recordAudit(db, r, auditAccountDeleted, caller.AccountID, 0, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Account deleted",
+		})
+	}
+}
+
+// eraseAccount anonymizes an account and its profiles in one transaction. It returns the storage
+// keys of the rider documents, shipment proof photos and uploaded images it removed, for the caller
+// to delete once the transaction is committed, or errAccountHasActiveShipments while a rider holds
+// one of the account's shipments.
+func eraseAccount(db *sql.DB, accountID int) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var phone string
+	if err := tx.QueryRow("SELECT phone_number FROM Accounts WHERE account_id = ? FOR UPDATE", accountID).Scan(&phone); err != nil {
+		return nil, err
+	}
+	placeholder := fmt.Sprintf("deleted-%d", accountID)
+
+	if err := cancelWaitingShipments(tx, accountID); err != nil {
+		return nil, err
+	}
+
+	var objectKeys []string
+	rows, err := tx.Query(`
+		SELECT d.object_key FROM Rider_Documents d JOIN Riders r ON r.rid = d.rid WHERE r.account_id = ?
+		UNION ALL
+		SELECT p.object_key FROM Shipment_Proofs p
+			JOIN Shipments s ON s.shipments = p.shipment_id
+			JOIN Users u ON u.uid = s.sender_id OR u.uid = s.receiver_id
+			WHERE u.account_id = ? AND p.object_key IS NOT NULL
+		UNION ALL
+		SELECT object_key FROM Uploads WHERE account_id = ?`,
+		accountID, accountID, accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		objectKeys = append(objectKeys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// Item photos of parcels the person sent, deleted from storage with the other uploads;
+		// descriptions stay for the receiver's record
+		{`UPDATE Shipment_Items si
+			JOIN Shipments s ON s.shipments = si.shipment_id
+			JOIN Users u ON u.uid = s.sender_id
+			SET si.image = NULL
+			WHERE u.account_id = ?`, []interface{}{accountID}},
+		// Pickup and delivery photos can show the person or their door; the time of the proof stays
+		{`UPDATE Shipment_Proofs p
+			JOIN Shipments s ON s.shipments = p.shipment_id
+			JOIN Users u ON u.uid = s.sender_id OR u.uid = s.receiver_id
+			SET p.object_key = NULL, p.content_type = NULL, p.size = NULL, p.location = NULL
+			WHERE u.account_id = ?`, []interface{}{accountID}},
+		// Where the rider was when they took a proof; the photo belongs to the sender's and receiver's record
+		{`UPDATE Shipment_Proofs SET location = NULL
+			WHERE rider_id IN (SELECT rid FROM Riders WHERE account_id = ?)`, []interface{}{accountID}},
+		// Copies of the person's details kept on shipments
+		{`UPDATE Shipments s JOIN Users u ON u.uid = s.sender_id
+			SET s.sender_name = 'Deleted user', s.sender_phone = ?, s.sender_address = '', s.sender_gps_location = NULL,
+				s.pickup_contact_name = NULL, s.pickup_notes = NULL
+			WHERE u.account_id = ?`, []interface{}{placeholder, accountID}},
+		{`UPDATE Shipments s JOIN Users u ON u.uid = s.receiver_id
+			SET s.receiver_name = 'Deleted user', s.receiver_phone = ?, s.receiver_address = '', s.receiver_gps_location = NULL,
+				s.dropoff_contact_name = NULL, s.dropoff_notes = NULL
+			WHERE u.account_id = ?`, []interface{}{placeholder, accountID}},
+		{"DELETE a FROM User_Addresses a JOIN Users u ON u.uid = a.uid WHERE u.account_id = ?", []interface{}{accountID}},
+		{`UPDATE Rider_Ratings rr JOIN Users u ON u.uid = rr.uid
+			SET rr.comment = NULL
+			WHERE u.account_id = ?`, []interface{}{accountID}},
+		{`UPDATE Users
+			SET phone_number = ?, name = 'Deleted user', profile_image = '', address = '',
+				gps_location = NULL
+			WHERE account_id = ?`, []interface{}{placeholder, accountID}},
+		{"DELETE d FROM Rider_Documents d JOIN Riders r ON r.rid = d.rid WHERE r.account_id = ?", []interface{}{accountID}},
+		{"DELETE FROM Uploads WHERE account_id = ?", []interface{}{accountID}},
+		{`UPDATE Riders
+			SET phone_number = ?, name = 'Deleted rider', profile_image = '', license_plate = '',
+				vehicle_type = NULL, vehicle_model = NULL, vehicle_color = NULL, verification_reason = NULL
+			WHERE account_id = ?`, []interface{}{placeholder, accountID}},
+		{`UPDATE Accounts
+			SET phone_number = ?, password = '', status = ?, verify_expires_at = NULL,
+				pending_phone_number = NULL, pending_phone_expires_at = NULL, deleted_at = UTC_TIMESTAMP()
+			WHERE account_id = ?`, []interface{}{placeholder, accountStatusDeleted, accountID}},
+		{"UPDATE Sessions SET revoked_at = UTC_TIMESTAMP() WHERE account_id = ? AND revoked_at IS NULL", []interface{}{accountID}},
+		{"UPDATE Api_Keys SET revoked_at = UTC_TIMESTAMP() WHERE account_id = ? AND revoked_at IS NULL", []interface{}{accountID}},
+		{"DELETE FROM Otp_Codes WHERE phone_number = ?", []interface{}{phone}},
+		{"DELETE FROM Login_Lockouts WHERE key_type = 'phone' AND key_value = ?", []interface{}{phone}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return nil, err
+		}
+	}
+	return objectKeys, tx.Commit()
+}
+
+// cancelWaitingShipments cancels the shipments the account sends or receives that are still waiting
+// for a rider, taking them off the job board. It returns errAccountHasActiveShipments without
+// changing anything when the account is on a shipment a rider has accepted, either as sender,
+// receiver or as the rider.
+func cancelWaitingShipments(tx *sql.Tx, accountID int) error {
+	rows, err := tx.Query(`
+		SELECT s.shipments, s.status FROM Shipments s
+		WHERE (s.sender_id IN (SELECT uid FROM Users WHERE account_id = ?)
+			OR s.receiver_id IN (SELECT uid FROM Users WHERE account_id = ?)
+			OR s.rider_id IN (SELECT rid FROM Riders WHERE account_id = ?))
+			AND s.status IN (`+shipmentStatusList(append([]ShipmentStatus{shipmentStatusWaiting}, activeShipmentStatuses...))+`)
+		FOR UPDATE`,
+		accountID, accountID, accountID,
+	)
+	if err != nil {
+		return err
+	}
+	var waiting []int64
+	active := false
+	for rows.Next() {
+		var id int64
+		var status ShipmentStatus
+		if err := rows.Scan(&id, &status); err != nil {
+			rows.Close()
+			return err
+		}
+		if status == shipmentStatusWaiting {
+			waiting = append(waiting, id)
+		} else {
+			active = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if active {
+		return errAccountHasActiveShipments
+	}
+
+	now := time.Now().UTC()
+	actor := auth.Identity{AccountID: accountID, Role: auth.RoleUser}
+	for _, id := range waiting {
+		if _, err := tx.Exec(
+			"UPDATE Shipments SET status = ?, status_updated_at = ? WHERE shipments = ?",
+			shipmentStatusCancelled, now, id,
+		); err != nil {
+			return err
+		}
+		if err := recordShipmentStatus(tx, id, shipmentStatusWaiting, shipmentStatusCancelled, actor, "account deleted", now); err != nil {
+			return err
+		}
+		if err := removeJob(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"delivery_webservice/config"
+	"delivery_webservice/notify"
+)
+
+const changePhoneMessage = "Your code to confirm this phone number is %s. Do not share this code with anyone."
+
+const otpPurposeChangePhone = "change_phone"
+
+// UserProfile is the sender profile of the logged-in account
+type UserProfile struct {
+	UID                int     `json:"uid"`
+	AccountID          int     `json:"account_id"`
+	PhoneNumber        string  `json:"phone_number"`
+	PendingPhoneNumber *string `json:"pending_phone_number"` // waiting for its SMS code
+	Name               string  `json:"name"`
+	ProfileImage       string  `json:"profile_image"`
+	Address            string  `json:"address"`
+	GpsLocation        *LatLng `json:"gps_location"`
+}
+
+// UpdateUserProfileRequest changes only the fields that are present
+type UpdateUserProfileRequest struct {
+	PhoneNumber  *string `json:"phone_number"`
+	Name         *string `json:"name"`
+	ProfileImage *string `json:"profile_image"`
+	Address      *string `json:"address"`
+	GpsLocation  *LatLng `json:"gps_location"`
+}
+
+// ConfirmPhoneChangeRequest carries the code sent to the new phone number
+type ConfirmPhoneChangeRequest struct {
+	Code string `json:"code"`
+}
+
+// getUserProfile loads the sender profile of an account
+func getUserProfile(db *sql.DB, accountID int) (UserProfile, error) {
+	var profile UserProfile
+	var pendingPhone sql.NullString
+	var pendingExpiresAt sql.NullTime
+	var location nullLatLng
+	err := db.QueryRow(`
+		SELECT u.uid, u.account_id, a.phone_number, a.pending_phone_number, a.pending_phone_expires_at,
+			u.name, COALESCE(u.profile_image, ''), COALESCE(u.address, ''), `+latLngColumns("u.gps_location")+`
+		FROM Users u
+		JOIN Accounts a ON a.account_id = u.account_id
+		WHERE u.account_id = ?`, accountID,
+	).Scan(&profile.UID, &profile.AccountID, &profile.PhoneNumber, &pendingPhone, &pendingExpiresAt,
+		&profile.Name, &profile.ProfileImage, &profile.Address, &location.Lat, &location.Lng)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	profile.GpsLocation = location.ptr()
+	if pendingPhone.Valid && pendingExpiresAt.Valid && time.Now().Before(pendingExpiresAt.Time) {
+		profile.PendingPhoneNumber = &pendingPhone.String
+	}
+	return profile, nil
+}
+
+// validate trims the code and checks that it was sent
+func (req *ConfirmPhoneChangeRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Code = trimSpace(req.Code)
+	requireField(fields, "code", req.Code)
+	return fields
+}
+
+// validate trims the fields that are present and returns a message for each invalid one
+func (req *UpdateUserProfileRequest) validate(current UserProfile) map[string]string {
+	fields := map[string]string{}
+	for _, f := range []*string{req.PhoneNumber, req.Name, req.ProfileImage, req.Address} {
+		if f != nil {
+			*f = trimSpace(*f)
+		}
+	}
+
+	validatePhoneField(fields, req.PhoneNumber)
+	validateNameField(fields, req.Name)
+	validateImageField(fields, req.ProfileImage)
+	if req.Address != nil {
+		validateMaxLen(fields, "address", *req.Address, 255)
+	}
+	validateLocationField(fields, "gps_location", req.GpsLocation)
+
+	// Registration needs an address or a location; an edit cannot take both away
+	address := current.Address
+	if req.Address != nil {
+		address = *req.Address
+	}
+	if address == "" && current.GpsLocation == nil && req.GpsLocation == nil {
+		fields["address"] = "either address or GPS location must be provided"
+	}
+	return fields
+}
+
+// phoneTaken reports whether another account already uses phone
+func phoneTaken(db *sql.DB, phone string, accountID int) (bool, error) {
+	var taken bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM Accounts WHERE phone_number = ? AND account_id <> ?)",
+		phone, accountID,
+	).Scan(&taken)
+	return taken, err
+}
+
+// requestPhoneChange sends a code to newPhone and holds the number on the account until
+// ConfirmPhoneChange. It writes the error response and returns false when the change cannot start.
+func requestPhoneChange(w http.ResponseWriter, r *http.Request, db *sql.DB, sms notify.SMSSender, accountID int, newPhone string) bool {
+	taken, err := phoneTaken(db, newPhone, accountID)
+	if err != nil {
+		log.Println("Error checking phone number:", err)
+		writeError(w, "Error updating profile", http.StatusInternalServerError)
+		return false
+	}
+	if taken {
+		writeFieldErrors(w, map[string]string{"phone_number": "is already registered"})
+		return false
+	}
+
+	err = issueOTP(r.Context(), db, sms, newPhone, otpPurposeChangePhone, changePhoneMessage)
+	var rateErr *otpRateLimitError
+	if errors.As(err, &rateErr) {
+		writeRetryAfter(w, rateErr.RetryAfter, "Too many codes requested, please try again later")
+		return false
+	} else if err != nil {
+		log.Println("Error sending phone change code:", err)
+		writeError(w, "Error updating profile", http.StatusInternalServerError)
+		return false
+	}
+
+	_, err = db.Exec(
+		"UPDATE Accounts SET pending_phone_number = ?, pending_phone_expires_at = ? WHERE account_id = ?",
+		newPhone, time.Now().UTC().Add(config.OTPTTL), accountID,
+	)
+	if err != nil {
+		log.Println("Error saving pending phone number:", err)
+		writeError(w, "Error updating profile", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// GetMyUserProfile returns the sender profile of the logged-in user
+func GetMyUserProfile(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		profile, err := getUserProfile(db, caller.AccountID)
+		if err == sql.ErrNoRows {
+			writeError(w, "User profile not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error loading user profile:", err)
+			writeError(w, "Failed to retrieve profile", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+	}
+}
+
+// UpdateMyUserProfile changes the fields present in the body. Shipments keep the details they
+// were created with. A new phone number only takes effect once the code sent to it is confirmed.
+func UpdateMyUserProfile(db *sql.DB, sms notify.SMSSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		var req UpdateUserProfileRequest
+		if !decodePatch(w, r, &req) {
+			return
+		}
+
+		current, err := getUserProfile(db, caller.AccountID)
+		if err == sql.ErrNoRows {
+			writeError(w, "User profile not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error loading user profile:", err)
+			writeError(w, "Error updating profile", http.StatusInternalServerError)
+			return
+		}
+
+		if fields := req.validate(current); len(fields) > 0 {
+			writeFieldErrors(w, fields)
+			return
+		}
+
+		// Send the code to the new number before saving anything, so a rate-limited request changes nothing
+		phoneChange := req.PhoneNumber != nil && *req.PhoneNumber != current.PhoneNumber
+		if phoneChange && !requestPhoneChange(w, r, db, sms, caller.AccountID, *req.PhoneNumber) {
+			return
+		}
+
+		var sets []string
+		var args []interface{}
+		if req.Name != nil {
+			sets = append(sets, "name = ?")
+			args = append(args, *req.Name)
+		}
+		if req.ProfileImage != nil {
+			sets = append(sets, "profile_image = ?")
+			args = append(args, *req.ProfileImage)
+		}
+		if req.Address != nil {
+			sets = append(sets, "address = ?")
+			args = append(args, *req.Address)
+		}
+		if req.GpsLocation != nil {
+			sets = append(sets, "gps_location = "+pointFromText)
+			args = append(args, req.GpsLocation.wkt())
+		}
+		if len(sets) > 0 {
+			args = append(args, caller.AccountID)
+			if _, err := db.Exec("UPDATE Users SET "+strings.Join(sets, ", ")+" WHERE account_id = ?", args...); err != nil {
+				log.Println("Error updating user profile:", err)
+				writeError(w, "Error updating profile", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		profile, err := getUserProfile(db, caller.AccountID)
+		if err != nil {
+			log.Println("Error loading user profile:", err)
+			writeError(w, "Error updating profile", http.StatusInternalServerError)
+			return
+		}
+
+		message := "Profile updated"
+		if phoneChange {
+			message += ", enter the code sent to the new phone number to finish changing it"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": message,
+			"profile": profile,
+		})
+	}
+}
+
+// ConfirmPhoneChange moves the account, and every role profile of it, to the pending phone number
+func ConfirmPhoneChange(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		var req ConfirmPhoneChangeRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		var oldPhone string
+		var pendingPhone sql.NullString
+		var pendingExpiresAt sql.NullTime
+		err := db.QueryRow(
+			"SELECT phone_number, pending_phone_number, pending_phone_expires_at FROM Accounts WHERE account_id = ?",
+			caller.AccountID,
+		).Scan(&oldPhone, &pendingPhone, &pendingExpiresAt)
+		if err != nil {
+			log.Println("Error loading pending phone number:", err)
+			writeError(w, "Error changing phone number", http.StatusInternalServerError)
+			return
+		}
+		if !pendingPhone.Valid || !pendingExpiresAt.Valid || time.Now().After(pendingExpiresAt.Time) {
+			writeError(w, "No phone number change is waiting for confirmation", http.StatusConflict)
+			return
+		}
+		newPhone := pendingPhone.String
+
+		if err := verifyOTP(db, newPhone, otpPurposeChangePhone, req.Code); errors.Is(err, errOTPInvalid) {
+			writeErrorCode(w, "Invalid or expired code", http.StatusBadRequest, codeInvalidCode)
+			return
+		} else if err != nil {
+			log.Println("Error verifying phone change code:", err)
+			writeError(w, "Error changing phone number", http.StatusInternalServerError)
+			return
+		}
+
+		// Free the number if an unverified registration is still holding it
+		if err := purgeExpiredPendingAccounts(db); err != nil {
+			log.Println("Error purging expired pending accounts:", err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error changing phone number", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var taken bool
+		err = tx.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM Accounts WHERE phone_number = ? AND account_id <> ? FOR UPDATE)",
+			newPhone, caller.AccountID,
+		).Scan(&taken)
+		if err != nil {
+			log.Println("Error checking phone number:", err)
+			writeError(w, "Error changing phone number", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			writeError(w, "Phone number already registered", http.StatusConflict)
+			return
+		}
+
+		statements := []string{
+			"UPDATE Accounts SET phone_number = ?, pending_phone_number = NULL, pending_phone_expires_at = NULL WHERE account_id = ?",
+			"UPDATE Users SET phone_number = ? WHERE account_id = ?",
+			"UPDATE Riders SET phone_number = ? WHERE account_id = ?",
+			"UPDATE Admins SET phone_number = ? WHERE account_id = ?",
+		}
+		for _, query := range statements {
+			if _, err := tx.Exec(query, newPhone, caller.AccountID); err != nil {
+				log.Println("Error changing phone number:", err)
+				writeError(w, "Error changing phone number", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing phone number change:", err)
+			writeError(w, "Error changing phone number", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, auditPhoneChange, caller.AccountID, 0, map[string]interface{}{"old_phone_hash": auditPhone(oldPhone), "new_phone_hash": auditPhone(newPhone)})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message":      "Phone number changed",
+			"phone_number": newPhone,
+		})
+	}
+}
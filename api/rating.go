@@ -0,0 +1,90 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// RateRiderRequest ให้คะแนนไรเดอร์ของการจัดส่งที่ส่งถึงแล้ว
+type RateRiderRequest struct {
+	Rating  int    `json:"rating"` // 1 ถึง 5
+	Comment string `json:"comment"`
+}
+
+// validate ตรวจคะแนนและความยาวของความคิดเห็น
+func (req *RateRiderRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Comment = trimSpace(req.Comment)
+	if req.Rating < 1 || req.Rating > 5 {
+		fields["rating"] = "must be between 1 and 5"
+	}
+	validateMaxLen(fields, "comment", req.Comment, 500)
+	return fields
+}
+
+// RateRider ให้ผู้ส่งให้คะแนนไรเดอร์ได้หนึ่งครั้งต่อการจัดส่งที่ส่งถึงแล้ว
+func RateRider(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		shipmentID, err := strconv.Atoi(mux.Vars(r)["shipment_id"])
+		if err != nil {
+			writeError(w, "Invalid shipment ID", http.StatusBadRequest)
+			return
+		}
+
+		var req RateRiderRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		var senderID int
+		var status ShipmentStatus
+		var riderID sql.NullInt64
+		var rated bool
+		err = db.QueryRow(`
+			SELECT s.sender_id, s.status, s.rider_id, EXISTS(SELECT 1 FROM Rider_Ratings WHERE shipment_id = s.shipments)
+			FROM Shipments s WHERE s.shipments = ?`, shipmentID,
+		).Scan(&senderID, &status, &riderID, &rated)
+		if err == sql.ErrNoRows || (err == nil && senderID != caller.ID) {
+			writeError(w, "Shipment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error loading shipment:", err)
+			writeError(w, "Error saving rating", http.StatusInternalServerError)
+			return
+		}
+		if status != shipmentStatusDelivered || !riderID.Valid {
+			writeError(w, "Only delivered shipments can be rated", http.StatusConflict)
+			return
+		}
+		if rated {
+			writeError(w, "Shipment already rated", http.StatusConflict)
+			return
+		}
+
+		_, err = db.Exec(
+			"INSERT INTO Rider_Ratings (shipment_id, rid, uid, rating, comment) VALUES (?, ?, ?, ?, NULLIF(?, ''))",
+			shipmentID, riderID.Int64, caller.ID, req.Rating, req.Comment,
+		)
+		if err != nil {
+			log.Println("Error saving rating:", err)
+			writeError(w, "Error saving rating", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Rating saved",
+		})
+	}
+}
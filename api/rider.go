@@ -3,9 +3,15 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"delivery_webservice/auth"
+	"delivery_webservice/notify"
 
 	"github.com/gorilla/mux"
 )
@@ -20,112 +26,386 @@ type RiderRegistrationRequest struct {
 	LicensePlate string `json:"license_plate"`
 }
 
-// RegisterRider จัดการการลงทะเบียนผู้ขับขี่
-func RegisterRider(db *sql.DB) http.HandlerFunc {
+// validate ตัดช่องว่างของทุกฟิลด์ แปลงเบอร์โทรศัพท์เป็นรูปแบบ E.164 และคืนข้อความของฟิลด์ที่ไม่ถูกต้อง
+func (req *RiderRegistrationRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Name = trimSpace(req.Name)
+	req.Password = trimSpace(req.Password)
+	req.ProfileImage = trimSpace(req.ProfileImage)
+	req.LicensePlate = trimSpace(req.LicensePlate)
+
+	if requireField(fields, "phone_number", req.PhoneNumber) {
+		validatePhoneField(fields, &req.PhoneNumber)
+	}
+	if requireField(fields, "name", req.Name) {
+		validateNameField(fields, &req.Name)
+	}
+	validatePasswordField(fields, "password", req.Password)
+	validateImageField(fields, &req.ProfileImage)
+	if requireField(fields, "license_plate", req.LicensePlate) {
+		validateMaxLen(fields, "license_plate", req.LicensePlate, 20)
+	}
+	return fields
+}
+
+// RegisterRider จัดการการลงทะเบียนผู้ขับขี่ เบอร์ใหม่จะได้บัญชีที่รอการยืนยันเบอร์โทรศัพท์
+// ส่วนเบอร์ที่เป็นผู้ใช้อยู่แล้วจะเพิ่มบทบาท rider ให้กับบัญชีเดิม
+func RegisterRider(db *sql.DB, sms notify.SMSSender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if db == nil {
-			http.Error(w, "Database connection not available", http.StatusInternalServerError)
+			writeError(w, "Database connection not available", http.StatusInternalServerError)
 			return
 		}
 
 		var req RiderRegistrationRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid data", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
-		// ตรวจสอบฟิลด์ที่ว่าง
-		if req.Name == "" || req.PhoneNumber == "" || req.Password == "" || req.LicensePlate == "" {
-			http.Error(w, "Fields cannot be empty", http.StatusBadRequest)
+		// ลบบัญชีที่ไม่ได้ยืนยันเบอร์โทรศัพท์ภายในเวลาที่กำหนด
+		if err := purgeExpiredPendingAccounts(db); err != nil {
+			log.Println("Error purging expired pending accounts:", err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error registering rider", http.StatusInternalServerError)
 			return
 		}
+		defer tx.Rollback()
 
-		// ตัดช่องว่าง
-		req.PhoneNumber = trimSpace(req.PhoneNumber)
-		req.Name = trimSpace(req.Name)
-		req.Password = trimSpace(req.Password)
-		req.LicensePlate = trimSpace(req.LicensePlate)
+		// ใช้บัญชีเดิมหากเบอร์นี้เป็นผู้ใช้อยู่แล้ว หรือสร้างบัญชีใหม่ที่รอการยืนยัน
+		accountID, status, created, err := claimAccountForRole(tx, req.PhoneNumber, req.Password, auth.RoleRider)
+		if errors.Is(err, errRoleExists) {
+			writeError(w, "Phone number already exists", http.StatusConflict)
+			return
+		} else if errors.Is(err, errPhoneRegistered) {
+			writeError(w, "Phone number already registered; use the password of your existing account to add the rider role", http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Println("Error preparing account:", err)
+			writeError(w, "Error registering rider", http.StatusInternalServerError)
+			return
+		}
+
+		// แทรกผู้ขับขี่ใหม่ลงในฐานข้อมูล
+		result, err := tx.Exec(
+			"INSERT INTO Riders (account_id, phone_number, name, profile_image, license_plate) VALUES (?, ?, ?, ?, ?)",
+			accountID, req.PhoneNumber, req.Name, req.ProfileImage, req.LicensePlate,
+		)
 
-		// ตรวจสอบหมายเลขโทรศัพท์
-		if phoneExists(db, req.PhoneNumber) {
-			http.Error(w, "Phone number already exists", http.StatusConflict)
+		// ตรวจสอบข้อผิดพลาด
+		if err != nil {
+			log.Println("Error registering rider:", err)
+			writeError(w, "Error registering rider", http.StatusInternalServerError)
 			return
 		}
 
-		// แฮชรหัสผ่าน
-		hashedPassword, err := hashPassword(req.Password)
+		// ดึง ID ของผู้ขับขี่ที่ถูกสร้างขึ้นมาใหม่ (ถ้าตารางมีการกำหนด auto-increment)
+		riderID, err := result.LastInsertId()
 		if err != nil {
-			log.Println("Error hashing password:", err)
-			http.Error(w, "Error hashing password", http.StatusInternalServerError)
+			log.Println("Error retrieving last insert ID:", err)
+			writeError(w, "Error registering rider", http.StatusInternalServerError)
 			return
 		}
 
-		// แทรกผู้ขับขี่ใหม่ลงในฐานข้อมูล
-result, err := db.Exec(
-	"INSERT INTO Riders (phone_number, password, name, profile_image, license_plate) VALUES (?, ?, ?, ?, ?)",
-	req.PhoneNumber, hashedPassword, req.Name, req.ProfileImage, req.LicensePlate,
-)
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing rider registration:", err)
+			writeError(w, "Error registering rider", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, auditRegister, accountID, 0, map[string]interface{}{"role": auth.RoleRider, "new_account": created})
 
-// ตรวจสอบข้อผิดพลาด
-if err != nil {
-	log.Println("Error registering rider:", err)
-	http.Error(w, fmt.Sprintf("Error registering rider: %v", err), http.StatusInternalServerError)
-	return
+		// ส่งรหัสยืนยันทาง SMS สำหรับบัญชีใหม่ หากส่งไม่สำเร็จผู้ใช้สามารถขอรหัสใหม่ได้
+		if created {
+			if err := issueOTP(r.Context(), db, sms, req.PhoneNumber, otpPurposeVerifyPhone, verifyPhoneMessage); err != nil {
+				log.Println("Error sending verification code:", err)
+			}
+		}
+
+		message := "Rider registration successful, upload your documents for verification"
+		if status == accountStatusPending {
+			message += " and verify your phone number"
+		}
+
+		// ส่งกลับข้อความยืนยันพร้อม ID ของผู้ขับขี่
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":             message,
+			"rider_id":            riderID, // ส่งกลับ ID ของผู้ขับขี่ใหม่
+			"account_id":          accountID,
+			"status":              status,
+			"verification_status": riderVerificationPending,
+		})
+	}
 }
 
-// ดึง ID ของผู้ขับขี่ที่ถูกสร้างขึ้นมาใหม่ (ถ้าตารางมีการกำหนด auto-increment)
-riderID, err := result.LastInsertId()
-if err != nil {
-	log.Println("Error retrieving last insert ID:", err)
-	http.Error(w, fmt.Sprintf("Error retrieving last insert ID: %v", err), http.StatusInternalServerError)
-	return
+// ประเภทยานพาหนะที่ไรเดอร์เลือกได้
+var riderVehicleTypes = map[string]bool{
+	"motorcycle": true,
+	"car":        true,
+	"pickup":     true,
+	"bicycle":    true,
 }
 
-// ส่งกลับข้อความยืนยันพร้อม ID ของผู้ขับขี่
-w.WriteHeader(http.StatusCreated)
-json.NewEncoder(w).Encode(map[string]interface{}{
-	"message":   "Rider registration successful",
-	"rider_id":  riderID, // ส่งกลับ ID ของผู้ขับขี่ใหม่
-})
+// RiderProfile คือข้อมูลไรเดอร์ที่แสดงให้ผู้ส่งเห็น
+// เบอร์โทรศัพท์แสดงเฉพาะเจ้าของ, admin และผู้ส่งหรือผู้รับของงานที่ไรเดอร์กำลังส่งอยู่
+type RiderProfile struct {
+	RID                 int      `json:"rid"`
+	Name                string   `json:"name"`
+	PhoneNumber         *string  `json:"phone_number,omitempty"`
+	ProfileImage        string   `json:"profile_image"`
+	LicensePlate        string   `json:"license_plate"`
+	VehicleType         string   `json:"vehicle_type"`
+	VehicleModel        string   `json:"vehicle_model"`
+	VehicleColor        string   `json:"vehicle_color"`
+	AverageRating       *float64 `json:"average_rating"` // nil จนกว่าจะมีคนให้คะแนน
+	RatingCount         int      `json:"rating_count"`
+	CompletedDeliveries int      `json:"completed_deliveries"`
+	VerificationStatus  string   `json:"verification_status"` // pending, approved หรือ rejected
+}
 
-	}
+// UpdateRiderProfileRequest แก้ไขเฉพาะฟิลด์ที่ส่งมา
+type UpdateRiderProfileRequest struct {
+	PhoneNumber  *string `json:"phone_number"`
+	Name         *string `json:"name"`
+	ProfileImage *string `json:"profile_image"`
+	LicensePlate *string `json:"license_plate"`
+	VehicleType  *string `json:"vehicle_type"`
+	VehicleModel *string `json:"vehicle_model"`
+	VehicleColor *string `json:"vehicle_color"`
 }
 
+// getRiderProfile ดึงข้อมูลไรเดอร์ตาม rid พร้อมคะแนนเฉลี่ยและจำนวนงานที่ส่งสำเร็จ
+func getRiderProfile(db *sql.DB, riderID int) (RiderProfile, string, error) {
+	var profile RiderProfile
+	var phone string
+	var average sql.NullFloat64
+	err := db.QueryRow(`
+		SELECT r.rid, r.name, r.phone_number, COALESCE(r.profile_image, ''), r.license_plate,
+			COALESCE(r.vehicle_type, ''), COALESCE(r.vehicle_model, ''), COALESCE(r.vehicle_color, ''), r.verification_status,
+			(SELECT AVG(rating) FROM Rider_Ratings WHERE rid = r.rid),
+			(SELECT COUNT(*) FROM Rider_Ratings WHERE rid = r.rid),
+			(SELECT COUNT(*) FROM Shipments WHERE rider_id = r.rid AND status = ?)
+		FROM Riders r
+		WHERE r.rid = ?`,
+		shipmentStatusDelivered, riderID,
+	).Scan(&profile.RID, &profile.Name, &phone, &profile.ProfileImage, &profile.LicensePlate,
+		&profile.VehicleType, &profile.VehicleModel, &profile.VehicleColor, &profile.VerificationStatus,
+		&average, &profile.RatingCount, &profile.CompletedDeliveries)
+	if err != nil {
+		return RiderProfile{}, "", err
+	}
+	if average.Valid {
+		rounded := math.Round(average.Float64*100) / 100
+		profile.AverageRating = &rounded
+	}
+	return profile, phone, nil
+}
 
-// RiderLicensePlateResponse is the structure for the response containing the rider's license plate
-type RiderLicensePlateResponse struct {
-	LicensePlate string `json:"license_plate"`
+// canSeeRiderPhone บอกว่าผู้เรียกเห็นเบอร์ของไรเดอร์ได้หรือไม่
+func canSeeRiderPhone(db *sql.DB, caller auth.Identity, riderID int) (bool, error) {
+	switch caller.Role {
+	case auth.RoleAdmin:
+		return true, nil
+	case auth.RoleRider:
+		return caller.ID == riderID, nil
+	case auth.RoleUser:
+		var active bool
+		err := db.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM Shipments WHERE rider_id = ? AND (sender_id = ? OR receiver_id = ?) AND status IN ("+shipmentStatusList(activeShipmentStatuses)+"))",
+			riderID, caller.ID, caller.ID,
+		).Scan(&active)
+		return active, err
+	}
+	return false, nil
 }
 
-// GetRider handles fetching rider's license plate information
+// GetRider ดึงโปรไฟล์ของไรเดอร์ตาม rider_id ใน URL
 func GetRider(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if db == nil {
-			http.Error(w, "Database connection not available", http.StatusInternalServerError)
+			writeError(w, "Database connection not available", http.StatusInternalServerError)
 			return
 		}
 
-		// Extract rider ID from the URL path (assuming the URL is like /get/rider/{rider_id})
-		vars := mux.Vars(r) // หากใช้ Gorilla Mux
-		riderID := vars["rider_id"]
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
 
-		// Query the rider's license plate from the database
-		var response RiderLicensePlateResponse
-		err := db.QueryRow("SELECT license_plate FROM Riders WHERE rid = ?", riderID).Scan(
-			&response.LicensePlate,
-		)
+		riderID, err := strconv.Atoi(mux.Vars(r)["rider_id"])
+		if err != nil {
+			writeError(w, "Invalid rider ID", http.StatusBadRequest)
+			return
+		}
 
+		profile, phone, err := getRiderProfile(db, riderID)
 		if err == sql.ErrNoRows {
-			http.Error(w, "Rider not found", http.StatusNotFound)
+			writeError(w, "Rider not found", http.StatusNotFound)
 			return
 		} else if err != nil {
 			log.Println("Error fetching rider:", err)
-			http.Error(w, fmt.Sprintf("Error fetching rider: %v", err), http.StatusInternalServerError)
+			writeError(w, "Error fetching rider", http.StatusInternalServerError)
 			return
 		}
 
-		// Send back the rider's license plate information as JSON
+		showPhone, err := canSeeRiderPhone(db, caller, riderID)
+		if err != nil {
+			log.Println("Error checking rider assignment:", err)
+			writeError(w, "Error fetching rider", http.StatusInternalServerError)
+			return
+		}
+		if showPhone {
+			profile.PhoneNumber = &phone
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+	}
+}
+
+// GetMyRiderProfile ดึงโปรไฟล์ของไรเดอร์ที่ล็อกอินอยู่
+func GetMyRiderProfile(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		profile, phone, err := getRiderProfile(db, caller.ID)
+		if err == sql.ErrNoRows {
+			writeError(w, "Rider not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error fetching rider:", err)
+			writeError(w, "Error fetching rider", http.StatusInternalServerError)
+			return
+		}
+		profile.PhoneNumber = &phone
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+	}
+}
+
+// validate ตัดช่องว่างของฟิลด์ที่ส่งมาและคืนข้อความของแต่ละฟิลด์ที่ไม่ถูกต้อง
+func (req *UpdateRiderProfileRequest) validate() map[string]string {
+	fields := map[string]string{}
+	for _, f := range []*string{req.PhoneNumber, req.Name, req.ProfileImage, req.LicensePlate, req.VehicleType, req.VehicleModel, req.VehicleColor} {
+		if f != nil {
+			*f = trimSpace(*f)
+		}
+	}
+
+	validatePhoneField(fields, req.PhoneNumber)
+	validateNameField(fields, req.Name)
+	validateImageField(fields, req.ProfileImage)
+	if req.LicensePlate != nil {
+		if *req.LicensePlate == "" {
+			fields["license_plate"] = "cannot be empty"
+		} else if len(*req.LicensePlate) > 20 {
+			fields["license_plate"] = "must be at most 20 characters"
+		}
+	}
+	if req.VehicleType != nil && !riderVehicleTypes[*req.VehicleType] {
+		fields["vehicle_type"] = "must be one of motorcycle, car, pickup or bicycle"
+	}
+	if req.VehicleModel != nil && len(*req.VehicleModel) > 64 {
+		fields["vehicle_model"] = "must be at most 64 characters"
+	}
+	if req.VehicleColor != nil && len(*req.VehicleColor) > 32 {
+		fields["vehicle_color"] = "must be at most 32 characters"
+	}
+	return fields
+}
+
+// UpdateMyRiderProfile แก้ไขโปรไฟล์และข้อมูลยานพาหนะของไรเดอร์ที่ล็อกอินอยู่
+// เบอร์โทรศัพท์ใหม่จะมีผลหลังจากยืนยันรหัสที่ส่งไปยังเบอร์นั้นแล้ว
+func UpdateMyRiderProfile(db *sql.DB, sms notify.SMSSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		var req UpdateRiderProfileRequest
+		if !decodePatch(w, r, &req) {
+			return
+		}
+
+		current, currentPhone, err := getRiderProfile(db, caller.ID)
+		if err == sql.ErrNoRows {
+			writeError(w, "Rider not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error fetching rider:", err)
+			writeError(w, "Error updating profile", http.StatusInternalServerError)
+			return
+		}
+
+		phoneChange := req.PhoneNumber != nil && *req.PhoneNumber != currentPhone
+		if phoneChange && !requestPhoneChange(w, r, db, sms, caller.AccountID, *req.PhoneNumber) {
+			return
+		}
+
+		var sets []string
+		var args []interface{}
+		for _, f := range []struct {
+			column string
+			value  *string
+		}{
+			{"name", req.Name},
+			{"profile_image", req.ProfileImage},
+			{"license_plate", req.LicensePlate},
+			{"vehicle_type", req.VehicleType},
+			{"vehicle_model", req.VehicleModel},
+			{"vehicle_color", req.VehicleColor},
+		} {
+			if f.value != nil {
+				sets = append(sets, f.column+" = ?")
+				args = append(args, *f.value)
+			}
+		}
+		// เปลี่ยนทะเบียนรถหรือประเภทรถหลังอนุมัติแล้ว ต้องส่งเอกสารให้แอดมินตรวจใหม่
+		reverify := current.VerificationStatus == riderVerificationApproved &&
+			((req.LicensePlate != nil && *req.LicensePlate != current.LicensePlate) ||
+				(req.VehicleType != nil && *req.VehicleType != current.VehicleType))
+		if reverify {
+			sets = append(sets, "verification_status = ?", "verification_submitted_at = NULL", "verified_at = NULL")
+			args = append(args, riderVerificationPending)
+		}
+		if len(sets) > 0 {
+			args = append(args, caller.ID)
+			if _, err := db.Exec("UPDATE Riders SET "+strings.Join(sets, ", ")+" WHERE rid = ?", args...); err != nil {
+				log.Println("Error updating rider profile:", err)
+				writeError(w, "Error updating profile", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		profile, phone, err := getRiderProfile(db, caller.ID)
+		if err != nil {
+			log.Println("Error fetching rider:", err)
+			writeError(w, "Error updating profile", http.StatusInternalServerError)
+			return
+		}
+		profile.PhoneNumber = &phone
+
+		message := "Profile updated"
+		if phoneChange {
+			message += ", enter the code sent to the new phone number to finish changing it"
+		}
+		if reverify {
+			message += ", your vehicle must be verified again before taking jobs"
+		}
+
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": message,
+			"profile": profile,
+		})
 	}
 }
@@ -0,0 +1,575 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"delivery_webservice/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// Values of Riders.verification_status
+const (
+	riderVerificationPending  = "pending"
+	riderVerificationApproved = "approved"
+	riderVerificationRejected = "rejected"
+)
+
+// riderDocumentTypes are the documents a rider must upload before submitting for review
+var riderDocumentTypes = []string{"driving_licence", "vehicle_registration", "national_id"}
+
+// RiderDocument describes an uploaded document without exposing where it is stored
+type RiderDocument struct {
+	Type       string    `json:"type"`
+	Size       int       `json:"size"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// RiderVerification is the state of a rider's onboarding review
+type RiderVerification struct {
+	RID         int             `json:"rid"`
+	Status      string          `json:"status"`
+	Reason      *string         `json:"reason,omitempty"` // why the last review rejected the rider
+	SubmittedAt *time.Time      `json:"submitted_at"`     // set while waiting in the review queue
+	VerifiedAt  *time.Time      `json:"verified_at"`
+	Documents   []RiderDocument `json:"documents"`
+	Missing     []string        `json:"missing_documents"`
+}
+
+// RiderVerificationQueueItem is a rider as listed in the admin review queue
+type RiderVerificationQueueItem struct {
+	RiderVerification
+	AccountID    int    `json:"account_id"`
+	Name         string `json:"name"`
+	PhoneNumber  string `json:"phone_number"`
+	LicensePlate string `json:"license_plate"`
+	VehicleType  string `json:"vehicle_type"`
+}
+
+// RiderReviewRequest is an admin's decision on a submitted rider
+type RiderReviewRequest struct {
+	Decision string `json:"decision"` // "approve" or "reject"
+	Reason   string `json:"reason"`   // required when rejecting
+}
+
+// validate checks the decision and that a rejection says why
+func (req *RiderReviewRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch req.Decision {
+	case "approve":
+	case "reject":
+		if req.Reason == "" {
+			fields["reason"] = "is required when rejecting"
+		}
+	default:
+		fields["decision"] = "must be approve or reject"
+	}
+	validateMaxLen(fields, "reason", req.Reason, 500)
+	return fields
+}
+
+// validRiderDocumentType reports whether t is one of riderDocumentTypes
+func validRiderDocumentType(t string) bool {
+	for _, docType := range riderDocumentTypes {
+		if docType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// riderApproved reports whether a rider has passed verification and may take jobs. q is a *sql.DB or *sql.Tx.
+func riderApproved(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, riderID int) (bool, error) {
+	var status string
+	err := q.QueryRow("SELECT verification_status FROM Riders WHERE rid = ?", riderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return status == riderVerificationApproved, err
+}
+
+// loadRiderVerification returns the review state and documents of a rider
+func loadRiderVerification(db *sql.DB, riderID int) (RiderVerification, error) {
+	v := RiderVerification{RID: riderID, Documents: []RiderDocument{}, Missing: []string{}}
+	var reason sql.NullString
+	var submittedAt, verifiedAt sql.NullTime
+	err := db.QueryRow(
+		"SELECT verification_status, verification_reason, verification_submitted_at, verified_at FROM Riders WHERE rid = ?",
+		riderID,
+	).Scan(&v.Status, &reason, &submittedAt, &verifiedAt)
+	if err != nil {
+		return RiderVerification{}, err
+	}
+	if reason.Valid {
+		v.Reason = &reason.String
+	}
+	if submittedAt.Valid {
+		v.SubmittedAt = &submittedAt.Time
+	}
+	if verifiedAt.Valid {
+		v.VerifiedAt = &verifiedAt.Time
+	}
+
+	rows, err := db.Query("SELECT doc_type, size, uploaded_at FROM Rider_Documents WHERE rid = ? ORDER BY doc_type", riderID)
+	if err != nil {
+		return RiderVerification{}, err
+	}
+	defer rows.Close()
+	uploaded := map[string]bool{}
+	for rows.Next() {
+		var doc RiderDocument
+		if err := rows.Scan(&doc.Type, &doc.Size, &doc.UploadedAt); err != nil {
+			return RiderVerification{}, err
+		}
+		uploaded[doc.Type] = true
+		v.Documents = append(v.Documents, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return RiderVerification{}, err
+	}
+	for _, docType := range riderDocumentTypes {
+		if !uploaded[docType] {
+			v.Missing = append(v.Missing, docType)
+		}
+	}
+	return v, nil
+}
+
+// errRiderDocumentsLocked is returned by saveRiderDocument for riders who are approved or whose
+// submission is waiting for review: a replaced file would be approved without an admin seeing it.
+var errRiderDocumentsLocked = errors.New("rider documents cannot be replaced now")
+
+// riderDocumentsReplaceable answers 409 when the rider may not replace documents right now
+func riderDocumentsReplaceable(w http.ResponseWriter, db *sql.DB, riderID int) bool {
+	var status string
+	var submittedAt sql.NullTime
+	err := db.QueryRow("SELECT verification_status, verification_submitted_at FROM Riders WHERE rid = ?", riderID).
+		Scan(&status, &submittedAt)
+	if err != nil {
+		log.Println("Error loading rider verification:", err)
+		writeError(w, "Error uploading document", http.StatusInternalServerError)
+		return false
+	}
+	if status == riderVerificationApproved || submittedAt.Valid {
+		writeError(w, "Documents cannot be replaced while approved or waiting for review", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// saveRiderDocument records an uploaded file as the rider's document of docType and returns the
+// key of the file it replaced, if any. The rider row is locked so a submission cannot slip in
+// between the check and the write.
+func saveRiderDocument(db *sql.DB, riderID int, docType, key, contentType string, size int) (sql.NullString, error) {
+	var oldKey sql.NullString
+	tx, err := db.Begin()
+	if err != nil {
+		return oldKey, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var submittedAt sql.NullTime
+	err = tx.QueryRow("SELECT verification_status, verification_submitted_at FROM Riders WHERE rid = ? FOR UPDATE", riderID).
+		Scan(&status, &submittedAt)
+	if err != nil {
+		return oldKey, err
+	}
+	if status == riderVerificationApproved || submittedAt.Valid {
+		return oldKey, errRiderDocumentsLocked
+	}
+
+	err = tx.QueryRow("SELECT object_key FROM Rider_Documents WHERE rid = ? AND doc_type = ?", riderID, docType).Scan(&oldKey)
+	if err != nil && err != sql.ErrNoRows {
+		return oldKey, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO Rider_Documents (rid, doc_type, object_key, content_type, size, uploaded_at)
+		VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())
+		ON DUPLICATE KEY UPDATE object_key = VALUES(object_key), content_type = VALUES(content_type),
+			size = VALUES(size), uploaded_at = VALUES(uploaded_at)`,
+		riderID, docType, key, contentType, size,
+	)
+	if err != nil {
+		return oldKey, err
+	}
+	return oldKey, tx.Commit()
+}
+
+// UploadRiderDocument stores one of the logged-in rider's documents, replacing an earlier file of
+// the same type. Files go under the private prefix and are only readable by admins. Documents are
+// locked from submission until the review, and for good once approved.
+func UploadRiderDocument(db *sql.DB, store storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		docType := mux.Vars(r)["doc_type"]
+		if !validRiderDocumentType(docType) {
+			writeError(w, "Unknown document type", http.StatusNotFound)
+			return
+		}
+
+		// Checked again under a lock before the document is saved
+		if !riderDocumentsReplaceable(w, db, caller.ID) {
+			return
+		}
+
+		data, contentType, ext, ok := readImageUpload(w, r)
+		if !ok {
+			return
+		}
+		key, err := newObjectKey(storage.PrivatePrefix+"rider-documents", ext)
+		if err != nil {
+			log.Println("Error generating object key:", err)
+			writeError(w, "Error uploading document", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Put(r.Context(), key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+			log.Println("Error storing rider document:", err)
+			writeError(w, "Error uploading document", http.StatusInternalServerError)
+			return
+		}
+
+		oldKey, err := saveRiderDocument(db, caller.ID, docType, key, contentType, len(data))
+		if err != nil {
+			if err := store.Delete(r.Context(), key); err != nil {
+				log.Println("Error deleting unsaved rider document:", err)
+			}
+			if errors.Is(err, errRiderDocumentsLocked) {
+				writeError(w, "Documents cannot be replaced while approved or waiting for review", http.StatusConflict)
+				return
+			}
+			log.Println("Error saving rider document:", err)
+			writeError(w, "Error uploading document", http.StatusInternalServerError)
+			return
+		}
+		if oldKey.Valid {
+			if err := store.Delete(r.Context(), oldKey.String); err != nil {
+				log.Println("Error deleting replaced rider document:", err)
+			}
+		}
+
+		verification, err := loadRiderVerification(db, caller.ID)
+		if err != nil {
+			log.Println("Error loading rider verification:", err)
+			writeError(w, "Error uploading document", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(verification)
+	}
+}
+
+// GetMyRiderVerification returns the review state of the logged-in rider and the documents still missing
+func GetMyRiderVerification(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		verification, err := loadRiderVerification(db, caller.ID)
+		if err != nil {
+			log.Println("Error loading rider verification:", err)
+			writeError(w, "Failed to retrieve verification", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(verification)
+	}
+}
+
+// SubmitRiderVerification puts the logged-in rider in the admin review queue once every document is uploaded
+func SubmitRiderVerification(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		verification, err := loadRiderVerification(db, caller.ID)
+		if err != nil {
+			log.Println("Error loading rider verification:", err)
+			writeError(w, "Error submitting verification", http.StatusInternalServerError)
+			return
+		}
+		if verification.Status == riderVerificationApproved {
+			writeError(w, "Rider is already approved", http.StatusConflict)
+			return
+		}
+		if len(verification.Missing) > 0 {
+			fields := map[string]string{}
+			for _, docType := range verification.Missing {
+				fields[docType] = "has not been uploaded"
+			}
+			writeAPIError(w, &APIError{
+				Status:  http.StatusConflict,
+				Code:    codeDocumentsMissing,
+				Message: "Documents are missing",
+				Fields:  fields,
+			})
+			return
+		}
+
+		_, err = db.Exec(`
+			UPDATE Riders
+			SET verification_status = ?, verification_reason = NULL, verification_submitted_at = UTC_TIMESTAMP()
+			WHERE rid = ? AND verification_status <> ?`,
+			riderVerificationPending, caller.ID, riderVerificationApproved,
+		)
+		if err != nil {
+			log.Println("Error submitting rider verification:", err)
+			writeError(w, "Error submitting verification", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, auditRiderVerificationSubmit, caller.AccountID, 0, map[string]interface{}{"rid": caller.ID})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Documents submitted for review",
+		})
+	}
+}
+
+// ListRiderVerifications is the admin review queue. Query parameters: status (pending, approved or
+// rejected; default pending), limit, offset. Pending riders are listed oldest submission first and
+// only once they have submitted.
+func ListRiderVerifications(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		status := params.Get("status")
+		if status == "" {
+			status = riderVerificationPending
+		}
+		query := `
+			SELECT r.rid, r.account_id, r.name, r.phone_number, r.license_plate, COALESCE(r.vehicle_type, '')
+			FROM Riders r
+			JOIN Accounts a ON a.account_id = r.account_id
+			WHERE a.deleted_at IS NULL AND r.verification_status = ?`
+		switch status {
+		case riderVerificationPending:
+			query += " AND r.verification_submitted_at IS NOT NULL ORDER BY r.verification_submitted_at, r.rid"
+		case riderVerificationApproved, riderVerificationRejected:
+			query += " ORDER BY r.rid DESC"
+		default:
+			writeError(w, "Unknown status", http.StatusBadRequest)
+			return
+		}
+
+		limit := 50
+		if v, err := strconv.Atoi(params.Get("limit")); err == nil && v > 0 && v <= 200 {
+			limit = v
+		}
+		offset := 0
+		if v, err := strconv.Atoi(params.Get("offset")); err == nil && v > 0 {
+			offset = v
+		}
+		query += " LIMIT ? OFFSET ?"
+
+		rows, err := db.Query(query, status, limit, offset)
+		if err != nil {
+			log.Println("Error listing rider verifications:", err)
+			writeError(w, "Failed to retrieve riders", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		items := []RiderVerificationQueueItem{}
+		for rows.Next() {
+			var item RiderVerificationQueueItem
+			if err := rows.Scan(&item.RID, &item.AccountID, &item.Name, &item.PhoneNumber, &item.LicensePlate, &item.VehicleType); err != nil {
+				log.Println("Error scanning rider:", err)
+				writeError(w, "Failed to retrieve riders", http.StatusInternalServerError)
+				return
+			}
+			items = append(items, item)
+		}
+		rows.Close()
+
+		for i := range items {
+			items[i].RiderVerification, err = loadRiderVerification(db, items[i].RID)
+			if err != nil {
+				log.Println("Error loading rider verification:", err)
+				writeError(w, "Failed to retrieve riders", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// GetRiderDocument streams a rider's document to an admin. Every view is written to the audit log.
+func GetRiderDocument(db *sql.DB, store storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		riderID, err := strconv.Atoi(mux.Vars(r)["rider_id"])
+		if err != nil {
+			writeError(w, "Invalid rider ID", http.StatusBadRequest)
+			return
+		}
+		docType := mux.Vars(r)["doc_type"]
+
+		var key, contentType string
+		var accountID int
+		err = db.QueryRow(`
+			SELECT d.object_key, d.content_type, r.account_id
+			FROM Rider_Documents d JOIN Riders r ON r.rid = d.rid
+			WHERE d.rid = ? AND d.doc_type = ?`, riderID, docType,
+		).Scan(&key, &contentType, &accountID)
+		if err == sql.ErrNoRows {
+			writeError(w, "Document not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error loading rider document:", err)
+			writeError(w, "Failed to retrieve document", http.StatusInternalServerError)
+			return
+		}
+
+		file, err := store.Get(r.Context(), key)
+		if err != nil {
+			log.Println("Error reading rider document:", err)
+			writeError(w, "Failed to retrieve document", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+		recordAudit(db, r, auditAdminRiderDocumentView, accountID, caller.AccountID, map[string]interface{}{"rid": riderID, "doc_type": docType})
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := io.Copy(w, file); err != nil {
+			log.Println("Error sending rider document:", err)
+		}
+	}
+}
+
+// ReviewRiderVerification approves or rejects a rider waiting in the review queue
+func ReviewRiderVerification(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		riderID, err := strconv.Atoi(mux.Vars(r)["rider_id"])
+		if err != nil {
+			writeError(w, "Invalid rider ID", http.StatusBadRequest)
+			return
+		}
+
+		var req RiderReviewRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		decision := riderVerificationApproved
+		if req.Decision == "reject" {
+			decision = riderVerificationRejected
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error reviewing rider", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var accountID, documents, changed int
+		var status string
+		var submittedAt sql.NullTime
+		err = tx.QueryRow(`
+			SELECT account_id, verification_status, verification_submitted_at,
+				(SELECT COUNT(*) FROM Rider_Documents WHERE rid = r.rid),
+				(SELECT COUNT(*) FROM Rider_Documents WHERE rid = r.rid AND uploaded_at > r.verification_submitted_at)
+			FROM Riders r WHERE rid = ? FOR UPDATE`, riderID,
+		).Scan(&accountID, &status, &submittedAt, &documents, &changed)
+		if err == sql.ErrNoRows {
+			writeError(w, "Rider not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error loading rider:", err)
+			writeError(w, "Error reviewing rider", http.StatusInternalServerError)
+			return
+		}
+		if status != riderVerificationPending || !submittedAt.Valid {
+			writeError(w, "Rider is not waiting for review", http.StatusConflict)
+			return
+		}
+		if decision == riderVerificationApproved && documents < len(riderDocumentTypes) {
+			writeError(w, "Rider has not uploaded every document", http.StatusConflict)
+			return
+		}
+		// Files replaced after submitting are not the ones the admin was shown in the queue
+		if decision == riderVerificationApproved && changed > 0 {
+			writeError(w, "Documents changed after the rider submitted them; the rider must submit again", http.StatusConflict)
+			return
+		}
+
+		now := time.Now().UTC()
+		var reason, verifiedAt interface{}
+		if decision == riderVerificationRejected {
+			reason = req.Reason
+		} else {
+			verifiedAt = now
+		}
+		if _, err := tx.Exec(`
+			UPDATE Riders
+			SET verification_status = ?, verification_reason = ?, verification_submitted_at = NULL, verified_at = ?
+			WHERE rid = ?`,
+			decision, reason, verifiedAt, riderID,
+		); err != nil {
+			log.Println("Error updating rider verification:", err)
+			writeError(w, "Error reviewing rider", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO Rider_Verification_Reviews (rid, decision, reason, admin_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			riderID, decision, req.Reason, caller.ID, now,
+		); err != nil {
+			log.Println("Error recording rider review:", err)
+			writeError(w, "Error reviewing rider", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing rider review:", err)
+			writeError(w, "Error reviewing rider", http.StatusInternalServerError)
+			return
+		}
+
+		event := auditAdminRiderApprove
+		if decision == riderVerificationRejected {
+			event = auditAdminRiderReject
+		}
+		recordAudit(db, r, event, accountID, caller.AccountID, map[string]interface{}{"rid": riderID, "reason": req.Reason})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Rider " + decision,
+			"rid":     riderID,
+			"status":  decision,
+		})
+	}
+}
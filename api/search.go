@@ -3,43 +3,58 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strings"
 )
 
+// SearchReceiverRequest คือเบอร์โทรศัพท์หรือส่วนต้นของเบอร์ที่ต้องการค้นหา
+type SearchReceiverRequest struct {
+	Phone string `json:"phone"`
+}
+
+// validate ตัดช่องว่างและตรวจว่าส่งเบอร์มา
+func (req *SearchReceiverRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Phone = strings.TrimSpace(req.Phone)
+	requireField(fields, "phone", req.Phone)
+	return fields
+}
+
 // SearchReceiverByPhone ค้นหาผู้รับตามเบอร์โทรศัพท์
 func SearchReceiverByPhone(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		var requestBody struct {
-			Phone  string `json:"phone"`
-			UserID int    `json:"user_id"` // รับ ID ของผู้ใช้ที่ล็อกอิน
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
 		}
 
-		err := json.NewDecoder(r.Body).Decode(&requestBody)
-		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		var requestBody SearchReceiverRequest
+		if !decodeJSON(w, r, &requestBody) {
 			return
 		}
 
-		phone := strings.TrimSpace(requestBody.Phone)
-		if phone == "" {
-			http.Error(w, "Phone number is required", http.StatusBadRequest)
+		// เบอร์ในฐานข้อมูลเป็นรูปแบบ E.164 จึงแปลงส่วนต้นของเบอร์ที่พิมพ์มาให้ตรงกันก่อนค้นหา
+		prefix := searchPhonePrefix(requestBody.Phone)
+		if prefix == "" {
+			writeError(w, "Receiver not found", http.StatusNotFound)
 			return
 		}
 
 		query := `
-			SELECT uid, name, phone_number, ST_X(gps_location) AS lat, ST_Y(gps_location) AS lng 
+			SELECT uid, name, phone_number, ` + latLngColumns("gps_location") + `
 			FROM Users 
 			WHERE phone_number LIKE ?
 		`
-		rows, err := db.Query(query, phone+"%")
+		rows, err := db.Query(query, prefix+"%")
 		if err != nil {
-			http.Error(w, "Failed to search for receiver", http.StatusInternalServerError)
+			log.Println("Error searching for receiver:", err)
+			writeError(w, "Failed to search for receiver", http.StatusInternalServerError)
 			return
 		}
 		defer rows.Close()
@@ -48,24 +63,25 @@ func SearchReceiverByPhone(db *sql.DB) http.HandlerFunc {
 		for rows.Next() {
 			var receiverID int
 			var receiverName, receiverPhone string
-			var lat, lng float64
-			if err := rows.Scan(&receiverID, &receiverName, &receiverPhone, &lat, &lng); err != nil {
-				http.Error(w, "Error scanning row", http.StatusInternalServerError)
+			var location nullLatLng
+			if err := rows.Scan(&receiverID, &receiverName, &receiverPhone, &location.Lat, &location.Lng); err != nil {
+				log.Println("Error scanning receiver:", err)
+				writeError(w, "Failed to search for receiver", http.StatusInternalServerError)
 				return
 			}
 			// ตรวจสอบว่าผู้รับเป็นผู้ใช้ที่ล็อกอินหรือไม่
-			if receiverID != requestBody.UserID {
+			if receiverID != caller.ID {
 				users = append(users, map[string]interface{}{
 					"receiver_id":    receiverID,
 					"receiver_name":  receiverName,
 					"receiver_phone": receiverPhone,
-					"gps_location":   map[string]float64{"lat": lat, "lng": lng},
+					"gps_location":   location.ptr(),
 				})
 			}
 		}
 
 		if len(users) == 0 {
-			http.Error(w, "Receiver not found", http.StatusNotFound)
+			writeError(w, "Receiver not found", http.StatusNotFound)
 			return
 		}
 
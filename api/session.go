@@ -0,0 +1,436 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"delivery_webservice/auth"
+	"delivery_webservice/config"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	errRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	errRefreshTokenReused  = errors.New("refresh token was already used")
+)
+
+// RefreshRequest carries the refresh token for the refresh and logout endpoints
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// validate checks that a refresh token was sent
+func (req *RefreshRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.RefreshToken = strings.TrimSpace(req.RefreshToken)
+	requireField(fields, "refresh_token", req.RefreshToken)
+	return fields
+}
+
+// newRandomToken returns n random bytes encoded as hex
+func newRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 of a token; only hashes are stored in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// insertRefreshToken creates a new refresh token for a session inside tx
+func insertRefreshToken(tx *sql.Tx, sessionID string) (string, time.Time, error) {
+	token, err := newRandomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(config.RefreshTokenTTL)
+	_, err = tx.Exec(
+		"INSERT INTO Refresh_Tokens (token_hash, session_id, expires_at) VALUES (?, ?, ?)",
+		hashToken(token), sessionID, expiresAt.UTC(),
+	)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// deviceInfo describes where a session was started
+type deviceInfo struct {
+	Name      string
+	Platform  string
+	IP        string
+	UserAgent string
+}
+
+// truncate cuts s to at most n bytes so it fits its column, without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// createSession starts a new token family for an account acting as role and returns its first refresh token
+func createSession(db *sql.DB, accountID int, role string, device deviceInfo) (string, string, time.Time, error) {
+	sessionID, err := newRandomToken(16)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO Sessions (sid, account_id, active_role, device_name, platform, ip, user_agent, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())",
+		sessionID, accountID, role, truncate(device.Name, 100), truncate(device.Platform, 32),
+		truncate(device.IP, 64), truncate(device.UserAgent, 255),
+	)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	refreshToken, expiresAt, err := insertRefreshToken(tx, sessionID)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", time.Time{}, err
+	}
+	return sessionID, refreshToken, expiresAt, nil
+}
+
+// rotateRefreshToken exchanges a refresh token for a new one in the same session. The returned
+// identity has the account, session and active role but not the role's profile ID.
+// Presenting a token that was already exchanged revokes the whole session, because
+// it means either the client or an attacker holds a stale copy.
+func rotateRefreshToken(db *sql.DB, token string) (auth.Identity, string, time.Time, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return auth.Identity{}, "", time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var identity auth.Identity
+	var expiresAt time.Time
+	var rotatedAt, revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT s.sid, s.active_role, s.account_id, s.revoked_at, t.expires_at, t.rotated_at
+		FROM Refresh_Tokens t
+		JOIN Sessions s ON s.sid = t.session_id
+		WHERE t.token_hash = ?
+		FOR UPDATE`, hashToken(token),
+	).Scan(&identity.SessionID, &identity.Role, &identity.AccountID, &revokedAt, &expiresAt, &rotatedAt)
+	if err == sql.ErrNoRows {
+		return auth.Identity{}, "", time.Time{}, errRefreshTokenInvalid
+	} else if err != nil {
+		return auth.Identity{}, "", time.Time{}, err
+	}
+
+	if revokedAt.Valid {
+		return auth.Identity{}, "", time.Time{}, errRefreshTokenInvalid
+	}
+
+	if rotatedAt.Valid {
+		if _, err := tx.Exec("UPDATE Sessions SET revoked_at = UTC_TIMESTAMP() WHERE sid = ?", identity.SessionID); err != nil {
+			return auth.Identity{}, "", time.Time{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return auth.Identity{}, "", time.Time{}, err
+		}
+		log.Printf("Refresh token reuse detected, revoked session %s of account %d", identity.SessionID, identity.AccountID)
+		return auth.Identity{}, "", time.Time{}, errRefreshTokenReused
+	}
+
+	if time.Now().After(expiresAt) {
+		return auth.Identity{}, "", time.Time{}, errRefreshTokenInvalid
+	}
+
+	if _, err := tx.Exec("UPDATE Refresh_Tokens SET rotated_at = UTC_TIMESTAMP() WHERE token_hash = ?", hashToken(token)); err != nil {
+		return auth.Identity{}, "", time.Time{}, err
+	}
+
+	newToken, newExpiresAt, err := insertRefreshToken(tx, identity.SessionID)
+	if err != nil {
+		return auth.Identity{}, "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return auth.Identity{}, "", time.Time{}, err
+	}
+	return identity, newToken, newExpiresAt, nil
+}
+
+// checkSession reports whether a session exists, has not been revoked and is still acting as role,
+// and whether its account is suspended. Access tokens issued before a role switch carry the old
+// role, so they stop working as soon as the session switches.
+func checkSession(db *sql.DB, sessionID, role string) (bool, bool, error) {
+	var activeRole string
+	var revokedAt, suspendedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT s.active_role, s.revoked_at, a.suspended_at
+		FROM Sessions s
+		JOIN Accounts a ON a.account_id = s.account_id
+		WHERE s.sid = ?`, sessionID,
+	).Scan(&activeRole, &revokedAt, &suspendedAt)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	return !revokedAt.Valid && activeRole == role, suspendedAt.Valid, nil
+}
+
+// touchSession records that a session was just used from ip. Writes are limited to one a minute per session.
+func touchSession(db *sql.DB, sessionID, ip string) {
+	_, err := db.Exec(`
+		UPDATE Sessions SET last_seen_at = UTC_TIMESTAMP(), ip = ?
+		WHERE sid = ? AND (last_seen_at IS NULL OR last_seen_at < UTC_TIMESTAMP() - INTERVAL 1 MINUTE)`,
+		truncate(ip, 64), sessionID,
+	)
+	if err != nil {
+		log.Println("Error updating session last seen:", err)
+	}
+}
+
+// revokeAllSessions revokes every active session of an account and returns how many were revoked
+func revokeAllSessions(db *sql.DB, accountID int) (int64, error) {
+	result, err := db.Exec(
+		"UPDATE Sessions SET revoked_at = UTC_TIMESTAMP() WHERE account_id = ? AND revoked_at IS NULL",
+		accountID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new refresh token
+func RefreshSession(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		identity, refreshToken, refreshExpiresAt, err := rotateRefreshToken(db, req.RefreshToken)
+		if errors.Is(err, errRefreshTokenInvalid) || errors.Is(err, errRefreshTokenReused) {
+			writeErrorCode(w, "Invalid or expired refresh token", http.StatusUnauthorized, codeInvalidToken)
+			return
+		} else if err != nil {
+			log.Println("Error rotating refresh token:", err)
+			writeError(w, "Error refreshing session", http.StatusInternalServerError)
+			return
+		}
+
+		// The session keeps acting as its active role, which the account must still hold
+		roles, err := loadAccountRoles(db, identity.AccountID)
+		if err != nil {
+			log.Println("Error loading account roles:", err)
+			writeError(w, "Error refreshing session", http.StatusInternalServerError)
+			return
+		}
+		id, ok := accountDetails{Roles: roles}.roleID(identity.Role)
+		if !ok {
+			writeErrorCode(w, "Invalid or expired refresh token", http.StatusUnauthorized, codeInvalidToken)
+			return
+		}
+		identity.ID = id
+		touchSession(db, identity.SessionID, clientIP(r))
+
+		accessToken, expiresAt, err := auth.IssueAccessToken(identity)
+		if err != nil {
+			log.Println("Error issuing access token:", err)
+			writeError(w, "Error issuing access token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":       accessToken,
+			"token_type":         "Bearer",
+			"expires_at":         expiresAt.Unix(),
+			"refresh_token":      refreshToken,
+			"refresh_expires_at": refreshExpiresAt.Unix(),
+		})
+	}
+}
+
+// Logout revokes the session that the given refresh token belongs to
+func Logout(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		// Unknown or already revoked tokens are not an error: the client is logged out either way
+		var sessionID string
+		var accountID int
+		err := db.QueryRow(`
+			SELECT s.sid, s.account_id
+			FROM Sessions s
+			JOIN Refresh_Tokens t ON t.session_id = s.sid
+			WHERE t.token_hash = ? AND s.revoked_at IS NULL`,
+			hashToken(req.RefreshToken),
+		).Scan(&sessionID, &accountID)
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("Error finding session:", err)
+			writeError(w, "Error logging out", http.StatusInternalServerError)
+			return
+		}
+
+		if err == nil {
+			if _, err := db.Exec("UPDATE Sessions SET revoked_at = UTC_TIMESTAMP() WHERE sid = ?", sessionID); err != nil {
+				log.Println("Error revoking session:", err)
+				writeError(w, "Error logging out", http.StatusInternalServerError)
+				return
+			}
+			recordAudit(db, r, auditLogout, accountID, 0, map[string]interface{}{"session_id": sessionID})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Logout successful",
+		})
+	}
+}
+
+// LogoutAllDevices revokes every session of the logged-in account
+func LogoutAllDevices(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		revoked, err := revokeAllSessions(db, caller.AccountID)
+		if err != nil {
+			log.Println("Error revoking sessions:", err)
+			writeError(w, "Error logging out", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, auditLogoutAll, caller.AccountID, 0, map[string]interface{}{"revoked_sessions": revoked})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":          "Logged out of all devices",
+			"revoked_sessions": revoked,
+		})
+	}
+}
+
+// ActiveSession is a signed-in device as shown to its owner
+type ActiveSession struct {
+	SessionID  string     `json:"session_id"`
+	DeviceName string     `json:"device_name"`
+	Platform   string     `json:"platform"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	ActiveRole string     `json:"active_role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	Current    bool       `json:"current"` // the session making this request
+}
+
+// ListSessions returns the active sessions of the logged-in account, most recently used first.
+// A session whose refresh token has expired can no longer be used and is left out.
+func ListSessions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT sid, device_name, platform, ip, user_agent, active_role, created_at, last_seen_at
+			FROM Sessions
+			WHERE account_id = ? AND revoked_at IS NULL
+				AND EXISTS (
+					SELECT 1 FROM Refresh_Tokens t
+					WHERE t.session_id = Sessions.sid AND t.rotated_at IS NULL AND t.expires_at > UTC_TIMESTAMP()
+				)
+			ORDER BY COALESCE(last_seen_at, created_at) DESC`,
+			caller.AccountID,
+		)
+		if err != nil {
+			log.Println("Error listing sessions:", err)
+			writeError(w, "Failed to retrieve sessions", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		sessions := []ActiveSession{}
+		for rows.Next() {
+			var session ActiveSession
+			var lastSeenAt sql.NullTime
+			err := rows.Scan(&session.SessionID, &session.DeviceName, &session.Platform, &session.IP,
+				&session.UserAgent, &session.ActiveRole, &session.CreatedAt, &lastSeenAt)
+			if err != nil {
+				log.Println("Error scanning session:", err)
+				writeError(w, "Failed to retrieve sessions", http.StatusInternalServerError)
+				return
+			}
+			if lastSeenAt.Valid {
+				session.LastSeenAt = &lastSeenAt.Time
+			}
+			session.Current = session.SessionID == caller.SessionID
+			sessions = append(sessions, session)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	}
+}
+
+// RevokeSession signs one of the caller's devices out; its tokens stop working on the next request
+func RevokeSession(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		sessionID := mux.Vars(r)["session_id"]
+
+		result, err := db.Exec(
+			"UPDATE Sessions SET revoked_at = UTC_TIMESTAMP() WHERE sid = ? AND account_id = ? AND revoked_at IS NULL",
+			sessionID, caller.AccountID,
+		)
+		if err != nil {
+			log.Println("Error revoking session:", err)
+			writeError(w, "Error revoking session", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			writeError(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		recordAudit(db, r, auditLogout, caller.AccountID, 0, map[string]interface{}{"session_id": sessionID, "from_session": caller.SessionID})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Session revoked",
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"Pixel 8", 20, "Pixel 8"},
+		{"Pixel 8", 5, "Pixel"},
+		{"ไอโฟน", 15, "ไอโฟน"},
+		{"ไอโฟน", 7, "ไอ"},
+		{"ไอโฟน", 2, ""},
+		{"a😀b", 4, "a"},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.in, tt.n)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) returned invalid UTF-8", tt.in, tt.n)
+		}
+	}
+}
@@ -3,9 +3,15 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"delivery_webservice/auth"
 
 	"github.com/gorilla/mux"
 )
@@ -24,19 +30,55 @@ type ShipmentItem struct {
 }
 
 // DeliveryRequest แสดงโครงสร้างข้อมูลการจัดส่ง
+// ผู้ส่งคือผู้ใช้ที่ล็อกอินอยู่ ไม่ได้รับมาจาก request body
 type DeliveryRequest struct {
-	SenderID      int            `json:"sender_id"`
-	ReceiverPhone string         `json:"receiver_phone,omitempty"` // Optional field
-	Items         []ShipmentItem `json:"items"`
+	ReceiverPhone    string         `json:"receiver_phone,omitempty"`     // Optional field
+	PickupAddressID  *int           `json:"pickup_address_id,omitempty"`  // ที่อยู่ที่บันทึกไว้ของผู้ส่ง ถ้าไม่ระบุใช้ที่อยู่หลัก
+	DropoffAddressID *int           `json:"dropoff_address_id,omitempty"` // ที่อยู่ที่บันทึกไว้ของผู้ส่ง ถ้าไม่ระบุใช้ที่อยู่หลักของผู้รับ
+	Items            []ShipmentItem `json:"items"`
+}
+
+// validate ตัดช่องว่าง แปลงเบอร์ผู้รับเป็นรูปแบบ E.164 และตรวจว่ามีสินค้าอย่างน้อยหนึ่งชิ้น
+func (req *DeliveryRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.ReceiverPhone = strings.TrimSpace(req.ReceiverPhone)
+	if req.ReceiverPhone != "" {
+		req.ReceiverPhone = lookupPhone(req.ReceiverPhone)
+	}
+	if len(req.Items) == 0 {
+		fields["items"] = "must contain at least one item"
+	}
+	for i := range req.Items {
+		req.Items[i].Description = trimSpace(req.Items[i].Description)
+		if req.Items[i].Description == "" {
+			fields[fmt.Sprintf("items[%d].description", i)] = "is required"
+		}
+	}
+	return fields
+}
+
+// ShipmentParty คือข้อมูลผู้ส่งหรือผู้รับ ณ เวลาที่สร้างการจัดส่ง
+// การแก้ไขโปรไฟล์ภายหลังจะไม่เปลี่ยนข้อมูลนี้
+type ShipmentParty struct {
+	Name        string  `json:"name"`
+	PhoneNumber string  `json:"phone_number"`
+	Address     string  `json:"address"`
+	Location    *LatLng `json:"location"`
+	ContactName string  `json:"contact_name,omitempty"` // ผู้ติดต่อ ณ จุดรับหรือจุดส่ง
+	RiderNotes  string  `json:"rider_notes,omitempty"`
 }
 
 type ShipmentDetail struct {
-	ShipmentID int            `json:"shipment_id"`
-	SenderID   string         `json:"sender_id"`
-	ReceiverID string         `json:"receiver_id"`
-	RiderID    *string        `json:"rider_id"` // ใช้ *string แทน
-	Status     string         `json:"status"`
-	Items      []ShipmentItem `json:"items"`
+	ShipmentID     int             `json:"shipment_id"`
+	SenderID       string          `json:"sender_id"`
+	ReceiverID     string          `json:"receiver_id"`
+	Sender         ShipmentParty   `json:"sender"`
+	Receiver       *ShipmentParty  `json:"receiver"` // nil เมื่อไม่ได้ระบุผู้รับ
+	RiderID        *string         `json:"rider_id"` // ใช้ *string แทน
+	Status         ShipmentStatus  `json:"status"`
+	RiderSuspended bool            `json:"rider_suspended"` // ไรเดอร์ถูกระงับบัญชีระหว่างการจัดส่ง
+	Items          []ShipmentItem  `json:"items"`
+	Proofs         []ShipmentProof `json:"proofs"` // หลักฐานตอนรับสินค้าและตอนส่งถึงผู้รับ
 }
 
 // type Shipment_id struct {
@@ -48,18 +90,13 @@ type ShipmentDetail struct {
 // CreateDelivery สร้างรายการจัดส่งใหม่
 func CreateDelivery(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req DeliveryRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid input", http.StatusBadRequest)
+		caller, ok := currentIdentity(w, r)
+		if !ok {
 			return
 		}
 
-		// Trim the receiver phone if provided
-		req.ReceiverPhone = strings.TrimSpace(req.ReceiverPhone)
-
-		// Check if items are provided
-		if len(req.Items) == 0 {
-			http.Error(w, "Items cannot be empty", http.StatusBadRequest)
+		var req DeliveryRequest
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
@@ -69,7 +106,7 @@ func CreateDelivery(db *sql.DB) http.HandlerFunc {
 			query := "SELECT uid FROM Users WHERE phone_number = ?"
 			err := db.QueryRow(query, req.ReceiverPhone).Scan(&receiverID)
 			if err != nil {
-				http.Error(w, "Receiver not found", http.StatusNotFound)
+				writeError(w, "Receiver not found", http.StatusNotFound)
 				return
 			}
 		} else {
@@ -79,16 +116,27 @@ func CreateDelivery(db *sql.DB) http.HandlerFunc {
 		// เริ่มต้น Transaction
 		tx, err := db.Begin()
 		if err != nil {
-			http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Failed to create shipment", http.StatusInternalServerError)
 			return
 		}
 
-		// สร้าง Shipment
-		insertQuery := "INSERT INTO Shipments (sender_id, receiver_id, status) VALUES (?, ?, ?)"
-		result, err := tx.Exec(insertQuery, req.SenderID, receiverID, 1) // สถานะ 1: รอ Rider
+		// สร้าง Shipment พร้อมคัดลอกข้อมูลผู้ส่งและผู้รับ ณ ตอนนี้เก็บไว้
+		insertQuery := `
+			INSERT INTO Shipments (sender_id, receiver_id, status, status_updated_at,
+				sender_name, sender_phone, sender_address, sender_gps_location,
+				receiver_name, receiver_phone, receiver_address, receiver_gps_location)
+			SELECT su.uid, ?, ?, UTC_TIMESTAMP(),
+				su.name, su.phone_number, su.address, su.gps_location,
+				ru.name, ru.phone_number, ru.address, ru.gps_location
+			FROM Users su
+			LEFT JOIN Users ru ON ru.uid = ?
+			WHERE su.uid = ?`
+		result, err := tx.Exec(insertQuery, receiverID, shipmentStatusWaiting, receiverID, caller.ID)
 		if err != nil {
 			tx.Rollback()
-			http.Error(w, "Failed to create shipment", http.StatusInternalServerError)
+			log.Println("Error creating shipment:", err)
+			writeError(w, "Failed to create shipment", http.StatusInternalServerError)
 			return
 		}
 
@@ -96,7 +144,72 @@ func CreateDelivery(db *sql.DB) http.HandlerFunc {
 		shipmentID, err := result.LastInsertId()
 		if err != nil {
 			tx.Rollback()
-			http.Error(w, "Failed to retrieve shipment ID", http.StatusInternalServerError)
+			log.Println("Error retrieving shipment ID:", err)
+			writeError(w, "Failed to create shipment", http.StatusInternalServerError)
+			return
+		}
+		if err := recordShipmentStatus(tx, shipmentID, 0, shipmentStatusWaiting, caller, "", time.Now().UTC()); err != nil {
+			tx.Rollback()
+			log.Println("Error recording shipment status:", err)
+			writeError(w, "Failed to create shipment", http.StatusInternalServerError)
+			return
+		}
+
+		// คัดลอกที่อยู่ที่บันทึกไว้มาเป็นจุดรับและจุดส่ง แทนที่อยู่ในโปรไฟล์
+		pickupID, err := resolveShipmentAddress(tx, req.PickupAddressID, caller.ID, caller.ID)
+		if errors.Is(err, errAddressNotFound) {
+			tx.Rollback()
+			writeFieldErrors(w, map[string]string{"pickup_address_id": "is not one of your saved addresses"})
+			return
+		} else if err != nil {
+			tx.Rollback()
+			log.Println("Error resolving pickup address:", err)
+			writeError(w, "Failed to create shipment", http.StatusInternalServerError)
+			return
+		}
+		dropoffID, err := resolveShipmentAddress(tx, req.DropoffAddressID, caller.ID, receiverID)
+		if errors.Is(err, errAddressNotFound) {
+			tx.Rollback()
+			writeFieldErrors(w, map[string]string{"dropoff_address_id": "is not one of your saved addresses"})
+			return
+		} else if err != nil {
+			tx.Rollback()
+			log.Println("Error resolving drop-off address:", err)
+			writeError(w, "Failed to create shipment", http.StatusInternalServerError)
+			return
+		}
+		if pickupID != 0 {
+			_, err = tx.Exec(`
+				UPDATE Shipments s JOIN User_Addresses a ON a.id = ?
+				SET s.pickup_address_id = a.id, s.sender_address = a.address, s.sender_gps_location = a.location,
+					s.pickup_contact_name = a.contact_name, s.pickup_notes = a.rider_notes
+				WHERE s.shipments = ?`, pickupID, shipmentID)
+			if err != nil {
+				tx.Rollback()
+				log.Println("Error copying pickup address:", err)
+				writeError(w, "Failed to create shipment", http.StatusInternalServerError)
+				return
+			}
+		}
+		if dropoffID != 0 {
+			_, err = tx.Exec(`
+				UPDATE Shipments s JOIN User_Addresses a ON a.id = ?
+				SET s.dropoff_address_id = a.id, s.receiver_address = a.address, s.receiver_gps_location = a.location,
+					s.dropoff_contact_name = a.contact_name, s.dropoff_notes = a.rider_notes
+				WHERE s.shipments = ?`, dropoffID, shipmentID)
+			if err != nil {
+				tx.Rollback()
+				log.Println("Error copying drop-off address:", err)
+				writeError(w, "Failed to create shipment", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// ลงงานบนกระดานงานหลังคัดลอกจุดรับแล้ว
+		if err := postJob(tx, shipmentID); err != nil {
+			tx.Rollback()
+			log.Println("Error posting job:", err)
+			writeError(w, "Failed to create shipment", http.StatusInternalServerError)
 			return
 		}
 
@@ -106,19 +219,22 @@ func CreateDelivery(db *sql.DB) http.HandlerFunc {
 			_, err := tx.Exec(insertItemQuery, shipmentID, item.Description, item.Image)
 			if err != nil {
 				tx.Rollback()
-				http.Error(w, "Failed to create shipment item", http.StatusInternalServerError)
+				log.Println("Error creating shipment item:", err)
+				writeError(w, "Failed to create shipment", http.StatusInternalServerError)
 				return
 			}
 		}
 
 		// ยืนยัน Transaction
 		if err := tx.Commit(); err != nil {
-			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+			log.Println("Error committing shipment:", err)
+			writeError(w, "Failed to create shipment", http.StatusInternalServerError)
 			return
 		}
 
-		response := map[string]string{
-			"message": "Delivery created successfully",
+		response := map[string]interface{}{
+			"message":     "Delivery created successfully",
+			"shipment_id": shipmentID,
 		}
 
 		w.WriteHeader(http.StatusCreated)
@@ -127,25 +243,28 @@ func CreateDelivery(db *sql.DB) http.HandlerFunc {
 
 }
 
-// GetDeliveryBySender ดึงข้อมูลรายการจัดส่งตาม sender_id
-func GetDeliveryBySender(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// ดึง sender_id จาก URL path
-		vars := mux.Vars(r)
-		senderID, ok := vars["sender_id"]
-		if !ok || senderID == "" {
-			http.Error(w, "Missing sender ID", http.StatusBadRequest)
-			return
-		}
-
-		// Query ข้อมูลการจัดส่ง
-		query := `
+// shipmentDetailQuery ดึงการจัดส่งพร้อมสินค้าทีละแถว ผู้เรียกต่อท้ายด้วยเงื่อนไข WHERE ของตัวเอง
+// แล้วอ่านผลด้วย scanShipmentDetails
+var shipmentDetailQuery = `
            SELECT 
                 s.shipments, 
                 s.sender_id, 
                 s.receiver_id, 
                 s.rider_id, 
                 s.status,
+                s.rider_suspended_at IS NOT NULL,
+                COALESCE(s.sender_name, ''),
+                COALESCE(s.sender_phone, ''),
+                COALESCE(s.sender_address, ''),
+                ` + latLngColumns("s.sender_gps_location") + `,
+                COALESCE(s.pickup_contact_name, ''),
+                COALESCE(s.pickup_notes, ''),
+                s.receiver_name,
+                COALESCE(s.receiver_phone, ''),
+                COALESCE(s.receiver_address, ''),
+                ` + latLngColumns("s.receiver_gps_location") + `,
+                COALESCE(s.dropoff_contact_name, ''),
+                COALESCE(s.dropoff_notes, ''),
                 si.iid,
                 si.description,
                 si.image
@@ -155,57 +274,144 @@ func GetDeliveryBySender(db *sql.DB) http.HandlerFunc {
                 Shipment_Items si 
             ON 
                 s.shipments = si.shipment_id
-            WHERE 
-                s.sender_id = ?
-        `
+`
+
+// scanShipmentDetails รวมแถวจาก shipmentDetailQuery เป็นการจัดส่งละหนึ่งรายการ ตามลำดับที่พบ
+// แล้วเติมหลักฐานการรับและส่งสินค้า
+func scanShipmentDetails(db *sql.DB, rows *sql.Rows) ([]ShipmentDetail, error) {
+	var deliveries []*ShipmentDetail
+	deliveriesMap := make(map[int]*ShipmentDetail)
 
-		var deliveries []ShipmentDetail
-		rows, err := db.Query(query, senderID)
+	for rows.Next() {
+		var shipmentID int
+		var delivery ShipmentDetail
+		var item ShipmentItem
+		var riderID sql.NullString // ใช้ sql.NullString เพื่อจัดการกับ NULL
+		var receiverName sql.NullString
+		var receiver ShipmentParty
+		var senderLocation, receiverLocation nullLatLng
+
+		// สแกนค่าจากฐานข้อมูล
+		err := rows.Scan(&shipmentID, &delivery.SenderID, &delivery.ReceiverID, &riderID, &delivery.Status, &delivery.RiderSuspended,
+			&delivery.Sender.Name, &delivery.Sender.PhoneNumber, &delivery.Sender.Address, &senderLocation.Lat, &senderLocation.Lng,
+			&delivery.Sender.ContactName, &delivery.Sender.RiderNotes,
+			&receiverName, &receiver.PhoneNumber, &receiver.Address, &receiverLocation.Lat, &receiverLocation.Lng,
+			&receiver.ContactName, &receiver.RiderNotes, &item.IID, &item.Description, &item.Image)
 		if err != nil {
-			http.Error(w, "Failed to retrieve delivery data", http.StatusInternalServerError)
-			return
+			return nil, err
 		}
-		defer rows.Close()
 
-		deliveriesMap := make(map[int]*ShipmentDetail)
+		// ตรวจสอบค่า riderID ว่าเป็น NULL หรือไม่
+		if riderID.Valid {
+			delivery.RiderID = &riderID.String // ถ้ามีค่า ให้กำหนดเป็น pointer
+		} else {
+			delivery.RiderID = nil // ถ้าเป็น NULL ให้กำหนดเป็น nil
+		}
 
-		for rows.Next() {
-			var shipmentID int
-			var delivery ShipmentDetail
-			var item ShipmentItem
-			var riderID sql.NullString // ใช้ sql.NullString เพื่อจัดการกับ NULL
+		// ผู้รับมีข้อมูลเมื่อระบุผู้รับหรือที่อยู่ปลายทางตอนสร้างการจัดส่ง
+		delivery.Sender.Location = senderLocation.ptr()
+		receiver.Location = receiverLocation.ptr()
+		if receiverName.Valid || receiver.Address != "" {
+			receiver.Name = receiverName.String
+			delivery.Receiver = &receiver
+		}
 
-			// สแกนค่าจากฐานข้อมูล
-			err := rows.Scan(&shipmentID, &delivery.SenderID, &delivery.ReceiverID, &riderID, &delivery.Status, &item.IID, &item.Description, &item.Image)
-			if err != nil {
-				log.Printf("Error scanning shipment data: %v", err)
-				http.Error(w, "Failed to scan shipment data", http.StatusInternalServerError)
-				return
-			}
+		// เพิ่มข้อมูลการจัดส่ง
+		if existingDelivery, found := deliveriesMap[shipmentID]; found {
+			existingDelivery.Items = append(existingDelivery.Items, item)
+		} else {
+			delivery.ShipmentID = shipmentID
+			delivery.Items = []ShipmentItem{item}
+			deliveriesMap[shipmentID] = &delivery
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-			// ตรวจสอบค่า riderID ว่าเป็น NULL หรือไม่
-			if riderID.Valid {
-				delivery.RiderID = &riderID.String // ถ้ามีค่า ให้กำหนดเป็น pointer
-			} else {
-				delivery.RiderID = nil // ถ้าเป็น NULL ให้กำหนดเป็น nil
-			}
+	shipmentIDs := make([]int, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		shipmentIDs = append(shipmentIDs, delivery.ShipmentID)
+	}
+	proofs, err := loadShipmentProofs(db, shipmentIDs)
+	if err != nil {
+		return nil, err
+	}
 
-			// เพิ่มข้อมูลการจัดส่ง
-			if existingDelivery, found := deliveriesMap[shipmentID]; found {
-				existingDelivery.Items = append(existingDelivery.Items, item)
-			} else {
-				delivery.ShipmentID = shipmentID
-				delivery.Items = []ShipmentItem{item}
-				deliveriesMap[shipmentID] = &delivery
-			}
+	result := make([]ShipmentDetail, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		delivery.Proofs = proofs[delivery.ShipmentID]
+		if delivery.Proofs == nil {
+			delivery.Proofs = []ShipmentProof{}
+		}
+		result = append(result, *delivery)
+	}
+	return result, nil
+}
+
+// shipmentVisible บอกว่าผู้เรียกดูการจัดส่งนี้ได้หรือไม่ คือผู้ส่ง ผู้รับ ไรเดอร์ที่รับงาน และแอดมิน
+// การจัดส่งที่ไม่มีอยู่ได้ false เหมือนการจัดส่งของคนอื่น
+func shipmentVisible(db *sql.DB, shipmentID int, caller auth.Identity) (bool, error) {
+	var senderID int
+	var receiverID, riderID sql.NullInt64
+	err := db.QueryRow("SELECT sender_id, receiver_id, rider_id FROM Shipments WHERE shipments = ?", shipmentID).
+		Scan(&senderID, &receiverID, &riderID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	switch caller.Role {
+	case auth.RoleAdmin:
+		return true, nil
+	case auth.RoleUser:
+		return senderID == caller.ID || (receiverID.Valid && int(receiverID.Int64) == caller.ID), nil
+	case auth.RoleRider:
+		return riderID.Valid && int(riderID.Int64) == caller.ID, nil
+	}
+	return false, nil
+}
+
+// GetDeliveryBySender ดึงข้อมูลรายการจัดส่งตาม sender_id
+func GetDeliveryBySender(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// ดึง sender_id จาก URL path
+		vars := mux.Vars(r)
+		senderID, ok := vars["sender_id"]
+		if !ok || senderID == "" {
+			writeError(w, "Missing sender ID", http.StatusBadRequest)
+			return
+		}
+
+		// ผู้ใช้ดูได้เฉพาะรายการจัดส่งของตัวเองเท่านั้น (admin ดูได้ทั้งหมด)
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		if caller.Role != auth.RoleAdmin && senderID != strconv.Itoa(caller.ID) {
+			writeForbidden(w)
+			return
 		}
 
-		for _, delivery := range deliveriesMap {
-			deliveries = append(deliveries, *delivery)
+		// Query ข้อมูลการจัดส่ง
+		rows, err := db.Query(shipmentDetailQuery+" WHERE s.sender_id = ?", senderID)
+		if err != nil {
+			log.Println("Error retrieving deliveries:", err)
+			writeError(w, "Failed to retrieve delivery data", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		deliveries, err := scanShipmentDetails(db, rows)
+		if err != nil {
+			log.Printf("Error scanning shipment data: %v", err)
+			writeError(w, "Failed to scan shipment data", http.StatusInternalServerError)
+			return
 		}
 
 		if len(deliveries) == 0 {
-			http.Error(w, "No shipments found for this sender", http.StatusNotFound)
+			writeError(w, "No shipments found for this sender", http.StatusNotFound)
 			return
 		}
 
@@ -214,7 +420,57 @@ func GetDeliveryBySender(db *sql.DB) http.HandlerFunc {
 
 		// สร้าง response
 		if err := json.NewEncoder(w).Encode(deliveries); err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			log.Println("Error encoding deliveries:", err)
+		}
+	}
+}
+
+// GetShipment คืนรายละเอียดการจัดส่งหนึ่งรายการ รวมหลักฐานการรับและส่งสินค้า
+// ให้ผู้ส่ง ผู้รับ ไรเดอร์ที่รับงาน และแอดมินดูได้
+func GetShipment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		shipmentID, ok := shipmentIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		visible, err := shipmentVisible(db, shipmentID, caller)
+		if err != nil {
+			log.Println("Error loading shipment:", err)
+			writeError(w, "Failed to retrieve delivery data", http.StatusInternalServerError)
+			return
+		}
+		if !visible {
+			writeError(w, "Shipment not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.Query(shipmentDetailQuery+" WHERE s.shipments = ?", shipmentID)
+		if err != nil {
+			log.Println("Error retrieving delivery:", err)
+			writeError(w, "Failed to retrieve delivery data", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		deliveries, err := scanShipmentDetails(db, rows)
+		if err != nil {
+			log.Printf("Error scanning shipment data: %v", err)
+			writeError(w, "Failed to scan shipment data", http.StatusInternalServerError)
+			return
+		}
+		if len(deliveries) == 0 {
+			writeError(w, "Shipment not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(deliveries[0]); err != nil {
+			log.Println("Error encoding delivery:", err)
 		}
 	}
 }
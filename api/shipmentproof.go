@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"delivery_webservice/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// ขั้นของการจัดส่งที่ไรเดอร์ต้องถ่ายรูปและส่งตำแหน่งเป็นหลักฐาน
+const (
+	proofStagePickup   = "pickup"
+	proofStageDelivery = "delivery"
+)
+
+// proofStages คือสถานะที่เปลี่ยนได้ผ่าน SubmitShipmentProof เท่านั้น และขั้นของหลักฐานที่ต้องมี
+var proofStages = map[ShipmentStatus]string{
+	shipmentStatusPickedUp:  proofStagePickup,
+	shipmentStatusDelivered: proofStageDelivery,
+}
+
+// shipmentProof คือรูปที่อัปโหลดแล้วและตำแหน่งของไรเดอร์ ส่งให้ transitionShipment บันทึกพร้อมการเปลี่ยนสถานะ
+type shipmentProof struct {
+	key         string
+	contentType string
+	size        int
+	location    LatLng
+	capturedAt  time.Time // recordShipmentProof ใส่ให้ เป็นเวลาเดียวกับการเปลี่ยนสถานะ
+}
+
+// ShipmentProof คือหลักฐานการรับหรือส่งสินค้าที่ผู้ส่งและผู้รับเห็นในรายละเอียดการจัดส่ง
+type ShipmentProof struct {
+	Stage      string    `json:"stage"`               // pickup หรือ delivery
+	PhotoURL   string    `json:"photo_url,omitempty"` // ว่างเมื่อรูปถูกลบไปพร้อมบัญชีของผู้ส่งหรือผู้รับ
+	Location   *LatLng   `json:"location"`
+	CapturedAt time.Time `json:"captured_at"`
+}
+
+// shipmentProofPhotoURL คือ path ของ GetShipmentProofPhoto รูปเก็บแบบ private จึงไม่มี URL ตรงของ blob store
+func shipmentProofPhotoURL(shipmentID int, stage string) string {
+	return fmt.Sprintf("/api/shipments/%d/proofs/%s/photo", shipmentID, stage)
+}
+
+// recordShipmentProof บันทึกหลักฐานใน transaction เดียวกับการเปลี่ยนสถานะ
+func recordShipmentProof(tx *sql.Tx, shipmentID int64, stage string, proof *shipmentProof, riderID int, at time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO Shipment_Proofs (shipment_id, stage, object_key, content_type, size, location, rider_id, captured_at)
+		VALUES (?, ?, ?, ?, ?, `+pointFromText+`, ?, ?)`,
+		shipmentID, stage, proof.key, proof.contentType, proof.size, proof.location.wkt(), riderID, at,
+	)
+	if err != nil {
+		return err
+	}
+	proof.capturedAt = at
+	return nil
+}
+
+// loadShipmentProofs คืนหลักฐานของการจัดส่งที่ระบุ แยกตามรหัสการจัดส่ง เรียงตามเวลาที่ถ่าย
+func loadShipmentProofs(db *sql.DB, shipmentIDs []int) (map[int][]ShipmentProof, error) {
+	proofs := map[int][]ShipmentProof{}
+	if len(shipmentIDs) == 0 {
+		return proofs, nil
+	}
+	args := make([]interface{}, len(shipmentIDs))
+	for i, id := range shipmentIDs {
+		args[i] = id
+	}
+	rows, err := db.Query(`
+		SELECT shipment_id, stage, object_key IS NOT NULL, `+latLngColumns("location")+`, captured_at
+		FROM Shipment_Proofs
+		WHERE shipment_id IN (?`+strings.Repeat(", ?", len(shipmentIDs)-1)+`)
+		ORDER BY shipment_id, captured_at, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shipmentID int
+		var proof ShipmentProof
+		var hasPhoto bool
+		var location nullLatLng
+		if err := rows.Scan(&shipmentID, &proof.Stage, &hasPhoto, &location.Lat, &location.Lng, &proof.CapturedAt); err != nil {
+			return nil, err
+		}
+		if hasPhoto {
+			proof.PhotoURL = shipmentProofPhotoURL(shipmentID, proof.Stage)
+		}
+		proof.Location = location.ptr()
+		proofs[shipmentID] = append(proofs[shipmentID], proof)
+	}
+	return proofs, rows.Err()
+}
+
+// SubmitShipmentProof ให้ไรเดอร์ยืนยันการรับสินค้า (pickup) หรือการส่งถึงผู้รับ (delivery)
+// ด้วยรูปใน multipart field "file" และตำแหน่งปัจจุบันใน field "lat" และ "lng"
+// สถานะจะเปลี่ยนและหลักฐานจะถูกบันทึกพร้อมกัน ถ้าเปลี่ยนสถานะไม่ได้รูปที่อัปโหลดจะถูกลบ
+func SubmitShipmentProof(db *sql.DB, store storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		shipmentID, ok := shipmentIDFromPath(w, r)
+		if !ok {
+			return
+		}
+		stage := mux.Vars(r)["stage"]
+		var to ShipmentStatus
+		for status, s := range proofStages {
+			if s == stage {
+				to = status
+			}
+		}
+		if to == 0 {
+			writeError(w, "Unknown proof stage", http.StatusNotFound)
+			return
+		}
+
+		data, contentType, ext, ok := readImageUpload(w, r)
+		if !ok {
+			return
+		}
+
+		fields := map[string]string{}
+		var location LatLng
+		var err error
+		if location.Lat, err = strconv.ParseFloat(trimSpace(r.FormValue("lat")), 64); err != nil {
+			fields["lat"] = "must be a number"
+		}
+		if location.Lng, err = strconv.ParseFloat(trimSpace(r.FormValue("lng")), 64); err != nil {
+			fields["lng"] = "must be a number"
+		}
+		if len(fields) == 0 {
+			if msg := location.validate(); msg != "" {
+				fields["lat"], fields["lng"] = msg, msg
+			}
+		}
+		if len(fields) > 0 {
+			writeFieldErrors(w, fields)
+			return
+		}
+
+		key, err := newObjectKey(storage.PrivatePrefix+"shipment-proofs", ext)
+		if err != nil {
+			log.Println("Error generating object key:", err)
+			writeError(w, "Error saving proof", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Put(r.Context(), key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+			log.Println("Error storing shipment proof:", err)
+			writeError(w, "Error saving proof", http.StatusInternalServerError)
+			return
+		}
+
+		proof := &shipmentProof{key: key, contentType: contentType, size: len(data), location: location}
+		from, err := transitionShipment(db, shipmentID, to, caller, "", proof)
+		if err != nil {
+			if err := store.Delete(r.Context(), key); err != nil {
+				log.Println("Error deleting unsaved shipment proof:", err)
+			}
+			writeTransitionError(w, err, "Error saving proof")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":     "Shipment status updated",
+			"shipment_id": shipmentID,
+			"from":        from,
+			"status":      to,
+			"proof": ShipmentProof{
+				Stage:      stage,
+				PhotoURL:   shipmentProofPhotoURL(shipmentID, stage),
+				Location:   &location,
+				CapturedAt: proof.capturedAt,
+			},
+		})
+	}
+}
+
+// GetShipmentProofPhoto ส่งรูปหลักฐานให้ผู้ส่ง ผู้รับ ไรเดอร์ที่รับงาน และแอดมิน
+func GetShipmentProofPhoto(db *sql.DB, store storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		shipmentID, ok := shipmentIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		visible, err := shipmentVisible(db, shipmentID, caller)
+		if err != nil {
+			log.Println("Error loading shipment:", err)
+			writeError(w, "Failed to retrieve proof", http.StatusInternalServerError)
+			return
+		}
+		if !visible {
+			writeError(w, "Shipment not found", http.StatusNotFound)
+			return
+		}
+
+		var key, contentType sql.NullString
+		err = db.QueryRow(
+			"SELECT object_key, content_type FROM Shipment_Proofs WHERE shipment_id = ? AND stage = ?",
+			shipmentID, mux.Vars(r)["stage"],
+		).Scan(&key, &contentType)
+		if err == sql.ErrNoRows || (err == nil && !key.Valid) {
+			writeError(w, "Proof not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error loading shipment proof:", err)
+			writeError(w, "Failed to retrieve proof", http.StatusInternalServerError)
+			return
+		}
+
+		file, err := store.Get(r.Context(), key.String)
+		if err != nil {
+			log.Println("Error reading shipment proof:", err)
+			writeError(w, "Failed to retrieve proof", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set("Content-Type", contentType.String)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := io.Copy(w, file); err != nil {
+			log.Println("Error sending shipment proof:", err)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"delivery_webservice/auth"
+	"delivery_webservice/config"
+
+	"github.com/gorilla/mux"
+)
+
+// ShipmentStatus คือสถานะของการจัดส่ง เก็บเป็นตัวเลขในคอลัมน์ Shipments.status
+// และส่งให้ client เป็นชื่อ ตัวเลขของสถานะที่มีอยู่แล้วห้ามเปลี่ยน
+type ShipmentStatus int
+
+const (
+	shipmentStatusWaiting       ShipmentStatus = 1 // รอ Rider
+	shipmentStatusRiderAssigned ShipmentStatus = 2 // Rider รับงานแล้ว กำลังไปรับสินค้า
+	shipmentStatusPickedUp      ShipmentStatus = 3 // Rider รับสินค้าแล้ว
+	shipmentStatusInTransit     ShipmentStatus = 4 // กำลังนำส่ง
+	shipmentStatusDelivered     ShipmentStatus = 5 // ส่งถึงผู้รับแล้ว
+	shipmentStatusCancelled     ShipmentStatus = 6 // ยกเลิกก่อนรับสินค้า
+	shipmentStatusFailed        ShipmentStatus = 7 // ส่งไม่สำเร็จหลังรับสินค้าแล้ว
+)
+
+var shipmentStatusNames = map[ShipmentStatus]string{
+	shipmentStatusWaiting:       "waiting",
+	shipmentStatusRiderAssigned: "rider_assigned",
+	shipmentStatusPickedUp:      "picked_up",
+	shipmentStatusInTransit:     "in_transit",
+	shipmentStatusDelivered:     "delivered",
+	shipmentStatusCancelled:     "cancelled",
+	shipmentStatusFailed:        "failed",
+}
+
+// activeShipmentStatuses คือสถานะที่ไรเดอร์กำลังถืองานอยู่
+var activeShipmentStatuses = []ShipmentStatus{shipmentStatusRiderAssigned, shipmentStatusPickedUp, shipmentStatusInTransit}
+
+// shipmentTransitions คือการเปลี่ยนสถานะที่ทำได้ทั้งหมด และบทบาทที่ทำได้ในแต่ละการเปลี่ยน
+// ผู้ใช้ต้องเป็นผู้ส่งของการจัดส่งนั้น ไรเดอร์ต้องเป็นผู้รับงาน ยกเว้นตอนรับงานที่ยังไม่มีไรเดอร์
+var shipmentTransitions = map[ShipmentStatus]map[ShipmentStatus][]string{
+	shipmentStatusWaiting: {
+		shipmentStatusRiderAssigned: {auth.RoleRider},
+		shipmentStatusCancelled:     {auth.RoleUser, auth.RoleAdmin},
+	},
+	shipmentStatusRiderAssigned: {
+		shipmentStatusWaiting:   {auth.RoleRider, auth.RoleAdmin}, // ไรเดอร์คืนงาน หรือแอดมินดึงงานกลับ
+		shipmentStatusPickedUp:  {auth.RoleRider},
+		shipmentStatusCancelled: {auth.RoleUser, auth.RoleAdmin},
+	},
+	shipmentStatusPickedUp: {
+		shipmentStatusInTransit: {auth.RoleRider},
+		shipmentStatusFailed:    {auth.RoleRider, auth.RoleAdmin},
+	},
+	shipmentStatusInTransit: {
+		shipmentStatusDelivered: {auth.RoleRider},
+		shipmentStatusFailed:    {auth.RoleRider, auth.RoleAdmin},
+	},
+}
+
+var (
+	errShipmentNotFound  = errors.New("shipment not found")
+	errShipmentForbidden = errors.New("role may not make this status change")
+	errRiderNotApproved  = errors.New("rider has not been approved")
+	errIllegalTransition = errors.New("illegal status change")
+	errShipmentTaken     = errors.New("shipment was taken by another rider")
+	errActiveJobLimit    = errors.New("rider already holds the maximum number of active jobs")
+	errProofRequired     = errors.New("status change needs a photo and position")
+)
+
+// String คืนชื่อของสถานะ
+func (s ShipmentStatus) String() string {
+	if name, ok := shipmentStatusNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalJSON ส่งสถานะเป็นชื่อ
+func (s ShipmentStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// parseShipmentStatus แปลงชื่อสถานะกลับเป็น ShipmentStatus
+func parseShipmentStatus(name string) (ShipmentStatus, bool) {
+	for status, n := range shipmentStatusNames {
+		if n == name {
+			return status, true
+		}
+	}
+	return 0, false
+}
+
+// shipmentStatusList คืนตัวเลขของสถานะคั่นด้วยจุลภาค สำหรับใช้ใน IN (...) ของ SQL
+func shipmentStatusList(statuses []ShipmentStatus) string {
+	parts := make([]string, len(statuses))
+	for i, s := range statuses {
+		parts[i] = strconv.Itoa(int(s))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// canTransition บอกว่าเปลี่ยนจากสถานะ from ไป to ได้หรือไม่ และบทบาทใดทำได้
+func canTransition(from, to ShipmentStatus) ([]string, bool) {
+	roles, ok := shipmentTransitions[from][to]
+	return roles, ok
+}
+
+// shipmentTransitionError บอกว่าเปลี่ยนสถานะไม่ได้เพราะสถานะปัจจุบันไม่อนุญาต
+type shipmentTransitionError struct {
+	From, To ShipmentStatus
+}
+
+func (e *shipmentTransitionError) Error() string {
+	return fmt.Sprintf("cannot change shipment status from %s to %s", e.From, e.To)
+}
+
+func (e *shipmentTransitionError) Unwrap() error {
+	return errIllegalTransition
+}
+
+// recordShipmentStatus บันทึกประวัติการเปลี่ยนสถานะ from เป็น 0 สำหรับการจัดส่งที่เพิ่งสร้าง
+func recordShipmentStatus(tx *sql.Tx, shipmentID int64, from, to ShipmentStatus, actor auth.Identity, reason string, at time.Time) error {
+	var fromStatus interface{}
+	if from != 0 {
+		fromStatus = from
+	}
+	_, err := tx.Exec(`
+		INSERT INTO Shipment_Status_History (shipment_id, from_status, to_status, actor_account_id, actor_role, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?)`,
+		shipmentID, fromStatus, to, actor.AccountID, actor.Role, reason, at,
+	)
+	return err
+}
+
+// transitionShipment เปลี่ยนสถานะของการจัดส่งเป็น to ในนามของ actor ทุกการเปลี่ยนสถานะต้องผ่านฟังก์ชันนี้
+// แถวของการจัดส่งถูกล็อกไว้ระหว่างตรวจและเปลี่ยน คำขอที่มาพร้อมกันจึงเห็นสถานะล่าสุดเสมอ
+// คืนสถานะเดิม หรือ errShipmentNotFound, errShipmentForbidden, errRiderNotApproved, errShipmentTaken,
+// errActiveJobLimit, errProofRequired, *shipmentTransitionError เมื่อเปลี่ยนไม่ได้
+// proof คือหลักฐานที่ต้องมีเมื่อเปลี่ยนเป็นสถานะใน proofStages และต้องเป็น nil สำหรับสถานะอื่น
+func transitionShipment(db *sql.DB, shipmentID int, to ShipmentStatus, actor auth.Identity, reason string, proof *shipmentProof) (ShipmentStatus, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var from ShipmentStatus
+	var senderID int
+	var riderID sql.NullInt64
+	err = tx.QueryRow(
+		"SELECT status, sender_id, rider_id FROM Shipments WHERE shipments = ? FOR UPDATE", shipmentID,
+	).Scan(&from, &senderID, &riderID)
+	if err == sql.ErrNoRows {
+		return 0, errShipmentNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	// ผู้ที่ไม่เกี่ยวข้องกับการจัดส่งจะเห็นเหมือนไม่มีการจัดส่งนี้
+	switch actor.Role {
+	case auth.RoleUser:
+		if senderID != actor.ID {
+			return 0, errShipmentNotFound
+		}
+	case auth.RoleRider:
+		// ไรเดอร์ทุกคนรับงานที่รออยู่ได้ การเปลี่ยนอื่นทำได้เฉพาะไรเดอร์ที่รับงานนั้นไว้
+		if to == shipmentStatusRiderAssigned {
+			if riderID.Valid {
+				return from, errShipmentTaken
+			}
+		} else if !riderID.Valid || int(riderID.Int64) != actor.ID {
+			return 0, errShipmentNotFound
+		}
+	case auth.RoleAdmin:
+	default:
+		return 0, errShipmentForbidden
+	}
+
+	roles, ok := canTransition(from, to)
+	if !ok {
+		return from, &shipmentTransitionError{From: from, To: to}
+	}
+	allowed := false
+	for _, role := range roles {
+		if role == actor.Role {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return from, errShipmentForbidden
+	}
+	if _, needsProof := proofStages[to]; needsProof != (proof != nil) {
+		return from, errProofRequired
+	}
+
+	now := time.Now().UTC()
+	query := "UPDATE Shipments SET status = ?, status_updated_at = ?"
+	args := []interface{}{to, now}
+	switch to {
+	case shipmentStatusRiderAssigned:
+		approved, err := riderApproved(tx, actor.ID)
+		if err != nil {
+			return from, err
+		}
+		if !approved {
+			return from, errRiderNotApproved
+		}
+		if err := checkActiveJobLimit(tx, actor.ID); err != nil {
+			return from, err
+		}
+		query += ", rider_id = ?, rider_suspended_at = NULL"
+		args = append(args, actor.ID)
+	case shipmentStatusWaiting:
+		query += ", rider_id = NULL, rider_suspended_at = NULL"
+	}
+	// เงื่อนไขสถานะเดิมกันไว้อีกชั้น แม้แถวจะถูกล็อกไว้แล้ว
+	query += " WHERE shipments = ? AND status = ?"
+	args = append(args, shipmentID, from)
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return from, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return from, err
+	} else if n != 1 {
+		return from, errShipmentTaken
+	}
+	if err := recordShipmentStatus(tx, int64(shipmentID), from, to, actor, reason, now); err != nil {
+		return from, err
+	}
+	if proof != nil {
+		if err := recordShipmentProof(tx, int64(shipmentID), proofStages[to], proof, actor.ID, now); err != nil {
+			return from, err
+		}
+	}
+
+	// กระดานงานมีเฉพาะการจัดส่งที่รอไรเดอร์
+	if from == shipmentStatusWaiting {
+		if err := removeJob(tx, int64(shipmentID)); err != nil {
+			return from, err
+		}
+	} else if to == shipmentStatusWaiting {
+		if err := postJob(tx, int64(shipmentID)); err != nil {
+			return from, err
+		}
+	}
+	return from, tx.Commit()
+}
+
+// ShipmentStatusRequest เปลี่ยนสถานะของการจัดส่ง ต้องระบุเหตุผลเมื่อยกเลิกหรือส่งไม่สำเร็จ
+type ShipmentStatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+
+	to ShipmentStatus
+}
+
+// validate แปลงชื่อสถานะและตรวจเหตุผล
+func (req *ShipmentStatusRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Status = strings.TrimSpace(req.Status)
+	req.Reason = trimSpace(req.Reason)
+	status, ok := parseShipmentStatus(req.Status)
+	if !ok {
+		fields["status"] = "is not a known shipment status"
+	}
+	req.to = status
+	if (status == shipmentStatusCancelled || status == shipmentStatusFailed) && req.Reason == "" {
+		fields["reason"] = "is required when cancelling or failing a shipment"
+	}
+	validateMaxLen(fields, "reason", req.Reason, 500)
+	return fields
+}
+
+// writeTransitionError ตอบกลับข้อผิดพลาดจาก transitionShipment
+func writeTransitionError(w http.ResponseWriter, err error, failMessage string) {
+	var transitionErr *shipmentTransitionError
+	switch {
+	case errors.Is(err, errShipmentNotFound):
+		writeError(w, "Shipment not found", http.StatusNotFound)
+	case errors.Is(err, errShipmentTaken):
+		writeErrorCode(w, "Shipment has already been taken by another rider", http.StatusConflict, codeShipmentTaken)
+	case errors.Is(err, errActiveJobLimit):
+		writeErrorCode(w, fmt.Sprintf("Riders can hold at most %d active jobs", config.RiderMaxActiveJobs), http.StatusConflict, codeActiveJobLimit)
+	case errors.As(err, &transitionErr):
+		writeErrorCode(w, "Shipment is "+transitionErr.From.String()+" and cannot become "+transitionErr.To.String(), http.StatusConflict, codeIllegalTransition)
+	case errors.Is(err, errShipmentForbidden):
+		writeForbidden(w)
+	case errors.Is(err, errProofRequired):
+		writeErrorCode(w, "Pickup and delivery need a photo and the rider's position; send them to /api/shipments/{shipment_id}/proofs/{stage}", http.StatusBadRequest, codeProofRequired)
+	case errors.Is(err, errRiderNotApproved):
+		writeErrorCode(w, "Rider has not been approved to take jobs", http.StatusForbidden, codeRiderNotApproved)
+	default:
+		log.Println("Error changing shipment status:", err)
+		writeError(w, failMessage, http.StatusInternalServerError)
+	}
+}
+
+// shipmentIDFromPath อ่าน {shipment_id} จาก URL
+func shipmentIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["shipment_id"])
+	if err != nil || id <= 0 {
+		writeError(w, "Invalid shipment ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// UpdateShipmentStatus เปลี่ยนสถานะของการจัดส่งตาม shipmentTransitions
+// การรับสินค้าและการส่งถึงผู้รับต้องมีหลักฐาน จึงเปลี่ยนผ่าน SubmitShipmentProof แทน
+func UpdateShipmentStatus(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		shipmentID, ok := shipmentIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		var req ShipmentStatusRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		from, err := transitionShipment(db, shipmentID, req.to, caller, req.Reason, nil)
+		if err != nil {
+			writeTransitionError(w, err, "Error updating shipment status")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":     "Shipment status updated",
+			"shipment_id": shipmentID,
+			"from":        from,
+			"status":      req.to,
+		})
+	}
+}
+
+// ShipmentStatusChange คือหนึ่งรายการในประวัติสถานะของการจัดส่ง
+type ShipmentStatusChange struct {
+	From      *ShipmentStatus `json:"from"` // nil สำหรับตอนสร้างการจัดส่ง
+	To        ShipmentStatus  `json:"to"`
+	ActorRole string          `json:"actor_role"`
+	Reason    *string         `json:"reason,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// GetShipmentStatusHistory คืนประวัติสถานะของการจัดส่ง ให้ผู้ส่ง ผู้รับ ไรเดอร์ที่รับงาน และแอดมินดูได้ ตาม shipmentVisible
+func GetShipmentStatusHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+		shipmentID, ok := shipmentIDFromPath(w, r)
+		if !ok {
+			return
+		}
+
+		visible, err := shipmentVisible(db, shipmentID, caller)
+		if err != nil {
+			log.Println("Error loading shipment:", err)
+			writeError(w, "Failed to retrieve status history", http.StatusInternalServerError)
+			return
+		}
+		if !visible {
+			writeError(w, "Shipment not found", http.StatusNotFound)
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT from_status, to_status, actor_role, reason, created_at
+			FROM Shipment_Status_History WHERE shipment_id = ? ORDER BY id`, shipmentID)
+		if err != nil {
+			log.Println("Error loading status history:", err)
+			writeError(w, "Failed to retrieve status history", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		history := []ShipmentStatusChange{}
+		for rows.Next() {
+			var change ShipmentStatusChange
+			var from sql.NullInt64
+			var reason sql.NullString
+			if err := rows.Scan(&from, &change.To, &change.ActorRole, &reason, &change.CreatedAt); err != nil {
+				log.Println("Error scanning status history:", err)
+				writeError(w, "Failed to retrieve status history", http.StatusInternalServerError)
+				return
+			}
+			if from.Valid {
+				status := ShipmentStatus(from.Int64)
+				change.From = &status
+			}
+			if reason.Valid {
+				change.Reason = &reason.String
+			}
+			history = append(history, change)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"delivery_webservice/config"
+)
+
+// loginThrottle counts failed logins per key (a phone number or an IP address)
+// and decides how long the key has to wait before its next attempt.
+type loginThrottle struct {
+	mu      sync.Mutex
+	entries map[string]*loginAttempts
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	inFlight     int // attempts reserved and not yet released
+}
+
+// throttleLimits are the thresholds for one kind of key
+type throttleLimits struct {
+	freeAttempts int
+	lockoutAt    int
+}
+
+var logins = &loginThrottle{entries: make(map[string]*loginAttempts)}
+
+// reserve lets an attempt for key go ahead and returns zero, or returns how long key must wait.
+// Attempts that go ahead are counted as in flight until release, and only as many may run at once
+// as the key has free attempts left, so a burst of parallel guesses cannot all reach bcrypt before
+// the first failure is counted. Once the free attempts are used up, one attempt runs at a time.
+func (t *loginThrottle) reserve(key string, limits throttleLimits, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.entries) > 10000 {
+		t.pruneLocked(now)
+	}
+
+	entry, ok := t.entries[key]
+	if !ok {
+		entry = &loginAttempts{}
+		t.entries[key] = entry
+	}
+	if wait := entry.blockedUntil.Sub(now); wait > 0 {
+		return wait
+	}
+	if entry.inFlight > 0 && entry.failures+entry.inFlight >= limits.freeAttempts {
+		return config.LoginBackoffBase
+	}
+	entry.inFlight++
+	return 0
+}
+
+// release ends an attempt started by reserve
+func (t *loginThrottle) release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if entry, ok := t.entries[key]; ok && entry.inFlight > 0 {
+		entry.inFlight--
+	}
+}
+
+// fail records a failed attempt for key. It returns the number of failures so far,
+// the time the key is blocked until, and whether this failure started a lockout.
+func (t *loginThrottle) fail(key string, limits throttleLimits, now time.Time) (int, time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.entries) > 10000 {
+		t.pruneLocked(now)
+	}
+
+	entry, ok := t.entries[key]
+	if !ok {
+		entry = &loginAttempts{}
+		t.entries[key] = entry
+	} else if now.Sub(entry.lastFailure) > config.LoginFailureWindow && now.After(entry.blockedUntil) {
+		entry.failures = 0
+	}
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures >= limits.lockoutAt {
+		entry.blockedUntil = now.Add(config.LoginLockoutDuration)
+		// Report the lockout once, when the threshold is first reached
+		return entry.failures, entry.blockedUntil, entry.failures == limits.lockoutAt
+	}
+
+	if over := entry.failures - limits.freeAttempts; over > 0 {
+		delay := config.LoginBackoffBase << (over - 1)
+		if delay <= 0 || delay > config.LoginLockoutDuration {
+			delay = config.LoginLockoutDuration
+		}
+		entry.blockedUntil = now.Add(delay)
+	}
+	return entry.failures, entry.blockedUntil, false
+}
+
+// reset forgets the failures of key after a successful login
+func (t *loginThrottle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if entry, ok := t.entries[key]; ok {
+		entry.failures = 0
+		entry.blockedUntil = time.Time{}
+	}
+}
+
+// pruneLocked drops entries that are neither blocked, recent nor in flight; t.mu must be held
+func (t *loginThrottle) pruneLocked(now time.Time) {
+	for key, entry := range t.entries {
+		if entry.inFlight == 0 && now.After(entry.blockedUntil) && now.Sub(entry.lastFailure) > config.LoginFailureWindow {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// codeRequestThrottle limits how often a key may ask for an SMS code. Unlike the per-phone limit in
+// issueOTP, it counts requests for numbers that have no account too, so the answer does not depend
+// on whether the number is registered.
+type codeRequestThrottle struct {
+	mu      sync.Mutex
+	entries map[string][]time.Time
+}
+
+var resetRequests = &codeRequestThrottle{entries: make(map[string][]time.Time)}
+
+// reserve counts a request for key and returns zero, or returns how long key must wait without
+// counting it. It applies OTPResendInterval and OTPMaxPerHour.
+func (t *codeRequestThrottle) reserve(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.entries) > 10000 {
+		for k, times := range t.entries {
+			if len(times) == 0 || now.Sub(times[len(times)-1]) > time.Hour {
+				delete(t.entries, k)
+			}
+		}
+	}
+
+	var recent []time.Time
+	for _, at := range t.entries[key] {
+		if now.Sub(at) < time.Hour {
+			recent = append(recent, at)
+		}
+	}
+	if n := len(recent); n > 0 {
+		if wait := recent[n-1].Add(config.OTPResendInterval).Sub(now); wait > 0 {
+			t.entries[key] = recent
+			return wait
+		}
+	}
+	if len(recent) >= config.OTPMaxPerHour {
+		t.entries[key] = recent
+		if len(recent) == 0 {
+			return time.Hour
+		}
+		return recent[0].Add(time.Hour).Sub(now)
+	}
+	t.entries[key] = append(recent, now)
+	return 0
+}
+
+func loginPhoneLimits() throttleLimits {
+	return throttleLimits{freeAttempts: config.LoginPhoneFreeAttempts, lockoutAt: config.LoginPhoneLockoutAt}
+}
+
+func loginIPLimits() throttleLimits {
+	return throttleLimits{freeAttempts: config.LoginIPFreeAttempts, lockoutAt: config.LoginIPLockoutAt}
+}
+
+// reserveLogin starts a login attempt for phone from ip and returns zero, or returns how long the
+// caller has to wait. An attempt that goes ahead must be ended with releaseLogin.
+func reserveLogin(phone, ip string) time.Duration {
+	now := time.Now()
+	if wait := logins.reserve("phone:"+phone, loginPhoneLimits(), now); wait > 0 {
+		return wait
+	}
+	if wait := logins.reserve("ip:"+ip, loginIPLimits(), now); wait > 0 {
+		logins.release("phone:" + phone)
+		return wait
+	}
+	return 0
+}
+
+// releaseLogin ends an attempt started by reserveLogin, after any failure has been counted
+func releaseLogin(phone, ip string) {
+	logins.release("phone:" + phone)
+	logins.release("ip:" + ip)
+}
+
+// loginFailed counts a failed login against both the phone and the IP and records any lockout it starts
+func loginFailed(db *sql.DB, phone, ip string) {
+	now := time.Now()
+	if failures, until, locked := logins.fail("phone:"+phone, loginPhoneLimits(), now); locked {
+		recordLockout(db, "phone", phone, failures, until)
+	}
+	if failures, until, locked := logins.fail("ip:"+ip, loginIPLimits(), now); locked {
+		recordLockout(db, "ip", ip, failures, until)
+	}
+}
+
+// loginSucceeded clears the failures of a phone number; the IP keeps its count until the window passes
+func loginSucceeded(phone string) {
+	logins.reset("phone:" + phone)
+}
+
+// clientIP returns the address of the caller, honouring X-Forwarded-For only when configured to
+func clientIP(r *http.Request) string {
+	if config.TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
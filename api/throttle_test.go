@@ -0,0 +1,92 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"delivery_webservice/config"
+)
+
+func TestCodeRequestThrottleReserve(t *testing.T) {
+	defer func(interval time.Duration, perHour int) {
+		config.OTPResendInterval, config.OTPMaxPerHour = interval, perHour
+	}(config.OTPResendInterval, config.OTPMaxPerHour)
+	config.OTPResendInterval, config.OTPMaxPerHour = time.Minute, 3
+
+	throttle := &codeRequestThrottle{entries: make(map[string][]time.Time)}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if wait := throttle.reserve("+66812345678", start); wait != 0 {
+		t.Fatalf("first request waits %s", wait)
+	}
+	if wait := throttle.reserve("+66812345678", start.Add(10*time.Second)); wait != 50*time.Second {
+		t.Fatalf("request within the resend interval waits %s, want 50s", wait)
+	}
+	// Numbers are limited alike whether or not they belong to an account
+	if wait := throttle.reserve("+66899999999", start.Add(10*time.Second)); wait != 0 {
+		t.Fatalf("another number waits %s", wait)
+	}
+	if wait := throttle.reserve("+66812345678", start.Add(time.Minute)); wait != 0 {
+		t.Fatalf("second request waits %s", wait)
+	}
+	if wait := throttle.reserve("+66812345678", start.Add(2*time.Minute)); wait != 0 {
+		t.Fatalf("third request waits %s", wait)
+	}
+	if wait := throttle.reserve("+66812345678", start.Add(3*time.Minute)); wait != 57*time.Minute {
+		t.Fatalf("request over the hourly limit waits %s, want 57m", wait)
+	}
+	if wait := throttle.reserve("+66812345678", start.Add(time.Hour)); wait != 0 {
+		t.Fatalf("request after the oldest one left the hour waits %s", wait)
+	}
+}
+
+func TestLoginThrottleReserveLimitsParallelAttempts(t *testing.T) {
+	defer func(base time.Duration) { config.LoginBackoffBase = base }(config.LoginBackoffBase)
+	config.LoginBackoffBase = time.Second
+
+	throttle := &loginThrottle{entries: make(map[string]*loginAttempts)}
+	limits := throttleLimits{freeAttempts: 2, lockoutAt: 10}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A burst gets as many attempts as there are free ones left, the rest wait
+	for i := 0; i < 2; i++ {
+		if wait := throttle.reserve("phone:x", limits, now); wait != 0 {
+			t.Fatalf("attempt %d waits %s", i+1, wait)
+		}
+	}
+	if wait := throttle.reserve("phone:x", limits, now); wait == 0 {
+		t.Fatal("third parallel attempt went ahead")
+	}
+	for i := 0; i < 2; i++ {
+		throttle.fail("phone:x", limits, now)
+		throttle.release("phone:x")
+	}
+
+	// With the free attempts used up only one attempt runs at a time
+	if wait := throttle.reserve("phone:x", limits, now); wait != 0 {
+		t.Fatalf("attempt after the free ones waits %s", wait)
+	}
+	if wait := throttle.reserve("phone:x", limits, now); wait == 0 {
+		t.Fatal("second parallel attempt went ahead")
+	}
+
+	// Its failure starts a backoff
+	throttle.fail("phone:x", limits, now)
+	throttle.release("phone:x")
+	if wait := throttle.reserve("phone:x", limits, now); wait != time.Second {
+		t.Fatalf("attempt during backoff waits %s, want 1s", wait)
+	}
+
+	// A successful login clears the failures
+	later := now.Add(time.Minute)
+	if wait := throttle.reserve("phone:x", limits, later); wait != 0 {
+		t.Fatalf("attempt after backoff waits %s", wait)
+	}
+	throttle.reset("phone:x")
+	throttle.release("phone:x")
+	for i := 0; i < 2; i++ {
+		if wait := throttle.reserve("phone:x", limits, later); wait != 0 {
+			t.Fatalf("attempt %d after a successful login waits %s", i+1, wait)
+		}
+	}
+}
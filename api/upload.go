@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"delivery_webservice/config"
+	"delivery_webservice/storage"
+)
+
+// Image types accepted by UploadImage, with the file extension used in their keys
+var uploadImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// What an upload is for; the key is prefixed with it. Each needs its prefix in storage.PublicPrefixes
+// for its URL to be readable on S3.
+var uploadPurposes = map[string]bool{
+	"profile": true,
+	"item":    true,
+}
+
+// UploadResult tells the client what to put in profile_image or an item image
+type UploadResult struct {
+	Key         string `json:"key"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+}
+
+// newObjectKey returns a key nobody can guess, such as "profile/2024/10/3f9a...e1.jpg"
+func newObjectKey(purpose, ext string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%s%s", purpose, time.Now().UTC().Format("2006/01"), hex.EncodeToString(b), ext), nil
+}
+
+// readImageUpload reads the JPEG or PNG sent as multipart field "file" and strips its metadata.
+// It writes the error response and returns false when the upload is missing, too large or not an image.
+func readImageUpload(w http.ResponseWriter, r *http.Request) ([]byte, string, string, bool) {
+	// Leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.UploadMaxBytes)+64<<10)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, fmt.Sprintf("File must be at most %d bytes", config.UploadMaxBytes), http.StatusRequestEntityTooLarge)
+			return nil, "", "", false
+		}
+		writeError(w, "Invalid multipart form", http.StatusBadRequest)
+		return nil, "", "", false
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeFieldErrors(w, map[string]string{"file": "is required"})
+		return nil, "", "", false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(config.UploadMaxBytes)+1))
+	if err != nil {
+		log.Println("Error reading upload:", err)
+		writeError(w, "Error uploading file", http.StatusInternalServerError)
+		return nil, "", "", false
+	}
+	if len(data) > config.UploadMaxBytes {
+		writeError(w, fmt.Sprintf("File must be at most %d bytes", config.UploadMaxBytes), http.StatusRequestEntityTooLarge)
+		return nil, "", "", false
+	}
+
+	// Trust the bytes, not the Content-Type the client claimed
+	contentType := http.DetectContentType(data)
+	ext, ok := uploadImageTypes[contentType]
+	if !ok {
+		writeError(w, "Only JPEG and PNG images are accepted", http.StatusUnsupportedMediaType)
+		return nil, "", "", false
+	}
+
+	data, err = stripImageMetadata(data, contentType)
+	if err != nil {
+		writeError(w, "Image file is damaged", http.StatusBadRequest)
+		return nil, "", "", false
+	}
+	return data, contentType, ext, true
+}
+
+// UploadImage stores a JPEG or PNG sent as multipart field "file", with form field "purpose"
+// set to "profile" or "item". Location and camera metadata are removed before it is stored.
+// The file is recorded against the caller's account, so it is deleted when the account is erased.
+func UploadImage(db *sql.DB, store storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := currentIdentity(w, r)
+		if !ok {
+			return
+		}
+
+		data, contentType, ext, ok := readImageUpload(w, r)
+		if !ok {
+			return
+		}
+
+		purpose := trimSpace(r.FormValue("purpose"))
+		if !uploadPurposes[purpose] {
+			writeFieldErrors(w, map[string]string{"purpose": "must be profile or item"})
+			return
+		}
+
+		key, err := newObjectKey(purpose, ext)
+		if err != nil {
+			log.Println("Error generating object key:", err)
+			writeError(w, "Error uploading file", http.StatusInternalServerError)
+			return
+		}
+		if err := store.Put(r.Context(), key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+			log.Println("Error storing upload:", err)
+			writeError(w, "Error uploading file", http.StatusInternalServerError)
+			return
+		}
+		if _, err := db.Exec(
+			"INSERT INTO Uploads (object_key, account_id, purpose, created_at) VALUES (?, ?, ?, UTC_TIMESTAMP())",
+			key, caller.AccountID, purpose,
+		); err != nil {
+			log.Println("Error recording upload:", err)
+			if err := store.Delete(r.Context(), key); err != nil {
+				log.Println("Error deleting unrecorded upload:", err)
+			}
+			writeError(w, "Error uploading file", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(UploadResult{
+			Key:         key,
+			URL:         store.URL(key),
+			ContentType: contentType,
+			Size:        len(data),
+		})
+	}
+}
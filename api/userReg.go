@@ -3,76 +3,102 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
+
+	"delivery_webservice/auth"
+	"delivery_webservice/notify"
 )
 
 // UserRegistrationRequest is the structure for user registration
 type UserRegistrationRequest struct {
-	PhoneNumber  string `json:"phone_number"`
-	Password     string `json:"password"`
-	Name         string `json:"name"`
-	ProfileImage string `json:"profile_image"`
-	Address      string `json:"address"`
-	GpsLocation  string `json:"gps_location"`
+	PhoneNumber  string  `json:"phone_number"`
+	Password     string  `json:"password"`
+	Name         string  `json:"name"`
+	ProfileImage string  `json:"profile_image"`
+	Address      string  `json:"address"`
+	GpsLocation  *LatLng `json:"gps_location"`
+}
+
+// validate trims the fields and puts the phone number in E.164 form
+func (req *UserRegistrationRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.Name = trimSpace(req.Name)
+	req.Password = trimSpace(req.Password)
+	req.ProfileImage = trimSpace(req.ProfileImage)
+	req.Address = trimSpace(req.Address)
+
+	if requireField(fields, "phone_number", req.PhoneNumber) {
+		validatePhoneField(fields, &req.PhoneNumber)
+	}
+	if requireField(fields, "name", req.Name) {
+		validateNameField(fields, &req.Name)
+	}
+	validatePasswordField(fields, "password", req.Password)
+	validateImageField(fields, &req.ProfileImage)
+	validateMaxLen(fields, "address", req.Address, 255)
+	validateLocationField(fields, "gps_location", req.GpsLocation)
+	if req.Address == "" && req.GpsLocation == nil {
+		fields["address"] = "either address or GPS location must be provided"
+	}
+	return fields
 }
 
-// RegisterUser handles user registration
-func RegisterUser(db *sql.DB) http.HandlerFunc {
+// RegisterUser handles user registration. A new phone number gets a pending account until it is
+// verified; a phone number that already belongs to a rider gets the user role added to that account.
+func RegisterUser(db *sql.DB, sms notify.SMSSender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if db == nil {
-			http.Error(w, "Database connection not available", http.StatusInternalServerError)
+			writeError(w, "Database connection not available", http.StatusInternalServerError)
 			return
 		}
 
 		var req UserRegistrationRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid data", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
-
-		// Check for empty fields
-		if req.Name == "" || req.PhoneNumber == "" || req.Password == "" {
-			http.Error(w, "Fields cannot be empty", http.StatusBadRequest)
-			return
+		var gpsWKT *string
+		if req.GpsLocation != nil {
+			wkt := req.GpsLocation.wkt()
+			gpsWKT = &wkt
 		}
 
-		// Ensure at least one of Address or GpsLocation is provided
-		if req.Address == "" && req.GpsLocation == "" {
-			http.Error(w, "Either address or GPS location must be provided", http.StatusBadRequest)
-			return
+		// Free phone numbers held by accounts that were never verified
+		if err := purgeExpiredPendingAccounts(db); err != nil {
+			log.Println("Error purging expired pending accounts:", err)
 		}
 
-		// Trim whitespace
-		req.PhoneNumber = trimSpace(req.PhoneNumber)
-		req.Name = trimSpace(req.Name)
-		req.Password = trimSpace(req.Password)
-		req.Address = trimSpace(req.Address)
-		req.GpsLocation = trimSpace(req.GpsLocation)
-
-		// Check if phone number already exists
-		if phoneExists(db, req.PhoneNumber) {
-			http.Error(w, "Phone number already exists", http.StatusConflict)
+		tx, err := db.Begin()
+		if err != nil {
+			log.Println("Error starting transaction:", err)
+			writeError(w, "Error registering user", http.StatusInternalServerError)
 			return
 		}
+		defer tx.Rollback()
 
-		// Hash password
-		hashedPassword, err := hashPassword(req.Password)
-		if err != nil {
-			log.Println("Error hashing password:", err)
-			http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		// Reuse the account of a rider with the same phone number, or create a pending one
+		accountID, status, created, err := claimAccountForRole(tx, req.PhoneNumber, req.Password, auth.RoleUser)
+		if errors.Is(err, errRoleExists) {
+			writeError(w, "Phone number already exists", http.StatusConflict)
+			return
+		} else if errors.Is(err, errPhoneRegistered) {
+			writeError(w, "Phone number already registered; use the password of your existing account to add the user role", http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Println("Error preparing account:", err)
+			writeError(w, "Error registering user", http.StatusInternalServerError)
 			return
 		}
 
-		// Insert new user into the database and retrieve the inserted ID
-		result, err := db.Exec(
-			"INSERT INTO Users (phone_number, password, name, profile_image, address, gps_location) VALUES (?, ?, ?, ?, ?, ST_GeomFromText(?))",
-			req.PhoneNumber, hashedPassword, req.Name, req.ProfileImage, req.Address, req.GpsLocation,
+		// Insert the user profile and retrieve the inserted ID
+		result, err := tx.Exec(
+			"INSERT INTO Users (account_id, phone_number, name, profile_image, address, gps_location) VALUES (?, ?, ?, ?, ?, "+pointFromText+")",
+			accountID, req.PhoneNumber, req.Name, req.ProfileImage, req.Address, gpsWKT,
 		)
 		if err != nil {
 			log.Println("Error registering user:", err)
-			http.Error(w, fmt.Sprintf("Error registering user: %v", err), http.StatusInternalServerError)
+			writeError(w, "Error registering user", http.StatusInternalServerError)
 			return
 		}
 
@@ -80,15 +106,37 @@ func RegisterUser(db *sql.DB) http.HandlerFunc {
 		userID, err := result.LastInsertId()
 		if err != nil {
 			log.Println("Error retrieving last insert ID:", err)
-			http.Error(w, "Error retrieving user ID", http.StatusInternalServerError)
+			writeError(w, "Error retrieving user ID", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Println("Error committing user registration:", err)
+			writeError(w, "Error registering user", http.StatusInternalServerError)
 			return
 		}
 
+		recordAudit(db, r, auditRegister, accountID, 0, map[string]interface{}{"role": auth.RoleUser, "new_account": created})
+
+		// Send the verification code for a new account; the client can ask for a new one if this fails
+		if created {
+			if err := issueOTP(r.Context(), db, sms, req.PhoneNumber, otpPurposeVerifyPhone, verifyPhoneMessage); err != nil {
+				log.Println("Error sending verification code:", err)
+			}
+		}
+
+		message := "User registration successful"
+		if status == accountStatusPending {
+			message += ", please verify your phone number"
+		}
+
 		// Send back success response with user ID
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"message": "User registration successful",
-			"id":      userID,
+			"message":    message,
+			"id":         userID,
+			"account_id": accountID,
+			"status":     status,
 		})
 	}
 }
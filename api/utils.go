@@ -1,9 +1,14 @@
 package api
 
 import (
-	"database/sql"
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"strings"
+	"sync"
+
+	"delivery_webservice/config"
+	"delivery_webservice/phone"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -13,22 +18,55 @@ func trimSpace(s string) string {
 	return strings.TrimSpace(s)
 }
 
-// phoneExists ตรวจสอบว่าหมายเลขโทรศัพท์มีอยู่ในตาราง Users หรือ Riders หรือไม่
-func phoneExists(db *sql.DB, phone string) bool {
-	var exists bool
-	query := `
-		SELECT EXISTS(SELECT 1 FROM Users WHERE phone_number = ?) 
-		OR EXISTS(SELECT 1 FROM Riders WHERE phone_number = ?)`
-	err := db.QueryRow(query, phone, phone).Scan(&exists)
-	if err != nil {
-		log.Printf("เกิดข้อผิดพลาดในการตรวจสอบหมายเลขโทรศัพท์: %v\n", err)
-		return false
+// normalizePhone แปลงเบอร์โทรศัพท์เป็นรูปแบบ E.164 สำหรับบันทึกลงฐานข้อมูล
+func normalizePhone(s string) (string, bool) {
+	normalized, err := phone.Normalize(s)
+	return normalized, err == nil
+}
+
+// lookupPhone แปลงเบอร์โทรศัพท์ที่ผู้ใช้พิมพ์มาเป็นรูปแบบ E.164 ก่อนนำไปค้นในฐานข้อมูล
+// เบอร์ที่แปลงไม่ได้จะคืนค่าเดิมที่ตัดช่องว่างแล้ว ซึ่งจะไม่ตรงกับบัญชีใด
+func lookupPhone(s string) string {
+	if normalized, err := phone.Normalize(s); err == nil {
+		return normalized
 	}
-	return exists
+	return trimSpace(s)
 }
 
-// hashPassword แฮชรหัสผ่านเป็นข้อความธรรมดาโดยใช้ bcrypt
+// searchPhonePrefix แปลงส่วนต้นของเบอร์โทรศัพท์ให้เป็นส่วนต้นของรูปแบบ E.164 สำหรับค้นหาด้วย LIKE
+func searchPhonePrefix(s string) string {
+	return phone.SearchPrefix(s)
+}
+
+// hashPassword แฮชรหัสผ่านเป็นข้อความธรรมดาโดยใช้ bcrypt ตามค่า cost ที่ตั้งไว้ใน config
 func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
 	return string(bytes), err
 }
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns a hash at the configured cost that no password matches. Logins for
+// unknown phone numbers are compared against it so they take as long as a wrong password.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		b := make([]byte, 32)
+		rand.Read(b)
+		hashed, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(b)), config.BcryptCost)
+		if err != nil {
+			log.Println("Error generating dummy password hash:", err)
+			return
+		}
+		dummyHash = string(hashed)
+	})
+	return dummyHash
+}
+
+// needsRehash reports whether a stored hash was made with a different cost than the configured one
+func needsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err == nil && cost != config.BcryptCost
+}
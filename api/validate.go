@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// bcrypt cannot hash more than 72 bytes, so longer passwords are a field error rather than a 500
+const maxPasswordBytes = 72
+
+// The validate*Field helpers below check one field of a request body. Pointer arguments are
+// optional fields of partial updates, where nil means "not sent" and is always valid. Messages are
+// written to fields under the field's JSON name, the way writeFieldErrors sends them.
+
+// requireField reports an empty required field
+func requireField(fields map[string]string, name, value string) bool {
+	if value == "" {
+		fields[name] = "is required"
+		return false
+	}
+	return true
+}
+
+// validateMaxLen reports a field longer than max bytes
+func validateMaxLen(fields map[string]string, name, value string, max int) {
+	if len(value) > max {
+		fields[name] = "must be at most " + strconv.Itoa(max) + " characters"
+	}
+}
+
+// validatePhoneField checks a phone number field, when present, and puts it in E.164 form
+func validatePhoneField(fields map[string]string, phone *string) {
+	if phone == nil {
+		return
+	}
+	normalized, ok := normalizePhone(*phone)
+	if !ok {
+		fields["phone_number"] = "is not a valid phone number"
+		return
+	}
+	*phone = normalized
+}
+
+// validateNameField checks a name field, when present
+func validateNameField(fields map[string]string, name *string) {
+	if name == nil {
+		return
+	}
+	if *name == "" {
+		fields["name"] = "cannot be empty"
+	} else if len(*name) > 255 {
+		fields["name"] = "must be at most 255 characters"
+	}
+}
+
+// validateImageField checks a profile_image field, when present; empty removes the photo
+func validateImageField(fields map[string]string, image *string) {
+	if image == nil || *image == "" {
+		return
+	}
+	u, err := url.ParseRequestURI(*image)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields["profile_image"] = "must be an http or https URL"
+	} else if len(*image) > 255 {
+		fields["profile_image"] = "must be at most 255 characters"
+	}
+}
+
+// validatePasswordField checks a password that is about to be hashed
+func validatePasswordField(fields map[string]string, name, password string) {
+	if requireField(fields, name, password) {
+		validateMaxLen(fields, name, password, maxPasswordBytes)
+	}
+}
+
+// validateLocationField checks a location field, when present
+func validateLocationField(fields map[string]string, name string, location *LatLng) {
+	if location == nil {
+		return
+	}
+	if msg := location.validate(); msg != "" {
+		fields[name] = msg
+	}
+}
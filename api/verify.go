@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"delivery_webservice/notify"
+)
+
+// Values of the status column in Accounts
+const (
+	accountStatusPending = "pending"
+	accountStatusActive  = "active"
+)
+
+const verifyPhoneMessage = "Your verification code is %s. Do not share this code with anyone."
+
+// VerifyPhoneRequest activates a pending account with the code sent by SMS
+type VerifyPhoneRequest struct {
+	PhoneNumber string `json:"phone_number"`
+	Code        string `json:"code"`
+}
+
+// ResendVerificationRequest asks for a new verification code
+type ResendVerificationRequest struct {
+	PhoneNumber string `json:"phone_number"`
+}
+
+// validate puts the phone number in the form it is stored in and checks that a code was sent
+func (req *VerifyPhoneRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.PhoneNumber = lookupPhone(req.PhoneNumber)
+	req.Code = trimSpace(req.Code)
+	requireField(fields, "phone_number", req.PhoneNumber)
+	requireField(fields, "code", req.Code)
+	return fields
+}
+
+// validate puts the phone number in the form it is stored in
+func (req *ResendVerificationRequest) validate() map[string]string {
+	fields := map[string]string{}
+	req.PhoneNumber = lookupPhone(req.PhoneNumber)
+	requireField(fields, "phone_number", req.PhoneNumber)
+	return fields
+}
+
+// purgeExpiredPendingAccounts deletes accounts that were never verified, with their role
+// profiles, so their phone numbers can be registered again
+func purgeExpiredPendingAccounts(db *sql.DB) error {
+	for _, table := range []string{"Users", "Riders", "Admins"} {
+		_, err := db.Exec(
+			"DELETE p FROM "+table+" p JOIN Accounts a ON a.account_id = p.account_id WHERE a.status = ? AND a.verify_expires_at < UTC_TIMESTAMP()",
+			accountStatusPending,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(
+		"DELETE FROM Accounts WHERE status = ? AND verify_expires_at < UTC_TIMESTAMP()",
+		accountStatusPending,
+	)
+	return err
+}
+
+// StartPendingAccountCleanup deletes expired pending accounts every interval until ctx is done
+func StartPendingAccountCleanup(ctx context.Context, db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := purgeExpiredPendingAccounts(db); err != nil {
+					log.Println("Error purging expired pending accounts:", err)
+				}
+			}
+		}
+	}()
+}
+
+// activatePendingAccount marks the pending account with this phone number as active
+func activatePendingAccount(db *sql.DB, phone string) (bool, error) {
+	result, err := db.Exec(
+		"UPDATE Accounts SET status = ?, verify_expires_at = NULL WHERE phone_number = ? AND status = ? AND verify_expires_at > UTC_TIMESTAMP()",
+		accountStatusActive, phone, accountStatusPending,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// VerifyPhone checks the SMS code sent at registration and activates the account
+func VerifyPhone(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyPhoneRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		if err := verifyOTP(db, req.PhoneNumber, otpPurposeVerifyPhone, req.Code); errors.Is(err, errOTPInvalid) {
+			writeErrorCode(w, "Invalid or expired code", http.StatusBadRequest, codeInvalidCode)
+			return
+		} else if err != nil {
+			log.Println("Error verifying phone code:", err)
+			writeError(w, "Error verifying phone number", http.StatusInternalServerError)
+			return
+		}
+
+		activated, err := activatePendingAccount(db, req.PhoneNumber)
+		if err != nil {
+			log.Println("Error activating account:", err)
+			writeError(w, "Error verifying phone number", http.StatusInternalServerError)
+			return
+		}
+		if !activated {
+			writeError(w, "No pending account for this phone number", http.StatusNotFound)
+			return
+		}
+		if account, err := getAccountByPhone(db, req.PhoneNumber); err == nil {
+			recordAudit(db, r, auditPhoneVerified, account.AccountID, 0, nil)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Phone number verified",
+		})
+	}
+}
+
+// ResendVerificationCode sends a new code to a pending account
+func ResendVerificationCode(db *sql.DB, sms notify.SMSSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResendVerificationRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		account, err := getAccountByPhone(db, req.PhoneNumber)
+		if err != nil {
+			log.Println("Error looking up account:", err)
+			writeError(w, "Error sending verification code", http.StatusInternalServerError)
+			return
+		}
+		if account.AccountID == 0 || account.Status != accountStatusPending {
+			writeError(w, "No pending account for this phone number", http.StatusNotFound)
+			return
+		}
+
+		err = issueOTP(r.Context(), db, sms, req.PhoneNumber, otpPurposeVerifyPhone, verifyPhoneMessage)
+		var rateErr *otpRateLimitError
+		if errors.As(err, &rateErr) {
+			writeRetryAfter(w, rateErr.RetryAfter, "Too many codes requested, please try again later")
+			return
+		} else if err != nil {
+			log.Println("Error issuing verification code:", err)
+			writeError(w, "Error sending verification code", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Verification code sent",
+		})
+	}
+}
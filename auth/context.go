@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	AccountID int    // Accounts.account_id
+	ID        int    // uid, rid or admin id of the active role
+	Role      string // active role
+	SessionID string // empty for API keys
+	APIKeyID  int    // set when the caller authenticated with an API key
+	Scopes    []string
+}
+
+// HasScope reports whether an API key caller was granted scope
+func (i Identity) HasScope(scope string) bool {
+	return slices.Contains(i.Scopes, scope)
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx that carries the caller's identity
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity stored by the auth middleware, if any
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
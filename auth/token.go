@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"delivery_webservice/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles carried in access tokens
+const (
+	RoleUser  = "user"
+	RoleRider = "rider"
+	RoleAdmin = "admin"
+)
+
+// Scopes that can be granted to API keys
+const (
+	ScopeDeliveriesCreate = "deliveries:create"
+	ScopeDeliveriesRead   = "deliveries:read"
+)
+
+// ValidScope reports whether scope is one of the known API key scopes
+func ValidScope(scope string) bool {
+	return scope == ScopeDeliveriesCreate || scope == ScopeDeliveriesRead
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleRider || role == RoleAdmin
+}
+
+// Claims is the payload of an access token
+type Claims struct {
+	AccountID int    `json:"aid"`  // Accounts.account_id, shared by every role of a phone number
+	ID        int    `json:"id"`   // uid for users, rid for riders, admin id for admins
+	Role      string `json:"role"` // active role: "user", "rider" or "admin"
+	SID       string `json:"sid"`  // session the token was issued for
+	jwt.RegisteredClaims
+}
+
+// IssueAccessToken signs a new access token for an identity acting in one of its roles
+func IssueAccessToken(id Identity) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(config.AccessTokenTTL)
+	claims := Claims{
+		AccountID: id.AccountID,
+		ID:        id.ID,
+		Role:      id.Role,
+		SID:       id.SessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(id.AccountID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.JWTSecret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// Identity returns the caller described by the claims
+func (c *Claims) Identity() Identity {
+	return Identity{AccountID: c.AccountID, ID: c.ID, Role: c.Role, SessionID: c.SID}
+}
+
+// ParseAccessToken verifies the signature and expiry of a token and returns its claims
+func ParseAccessToken(tokenString string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return config.JWTSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.AccountID <= 0 || claims.ID <= 0 || claims.SID == "" || !ValidRole(claims.Role) {
+		return nil, errors.New("token has an invalid identity")
+	}
+	return &claims, nil
+}
@@ -0,0 +1,90 @@
+// Command createadmin gives an account the admin role, creating the account if the phone number is new.
+//
+//	ADMIN_PASSWORD=... go run ./cmd/createadmin -phone 0812345678 -name "Ops"
+//
+// ADMIN_PASSWORD is only used when a new account is created. The command reads the same settings as
+// the server, so JWT_SECRET must be set and BCRYPT_COST applies to the new password.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"delivery_webservice/config"
+	"delivery_webservice/phone"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func main() {
+	phoneNumber := flag.String("phone", "", "phone number used to log in")
+	name := flag.String("name", "", "display name")
+	flag.Parse()
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	*phoneNumber = strings.TrimSpace(*phoneNumber)
+	*name = strings.TrimSpace(*name)
+	if *phoneNumber == "" || *name == "" {
+		log.Fatal("usage: ADMIN_PASSWORD=... createadmin -phone <phone> -name <name>")
+	}
+	normalized, err := phone.Normalize(*phoneNumber)
+	if err != nil {
+		log.Fatalf("Invalid phone number %q", *phoneNumber)
+	}
+	*phoneNumber = normalized
+
+	config.LoadSettings()
+	config.Connect()
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		log.Fatal("Error starting transaction: ", err)
+	}
+	defer tx.Rollback()
+
+	var accountID int64
+	err = tx.QueryRow("SELECT account_id FROM Accounts WHERE phone_number = ? FOR UPDATE", *phoneNumber).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		if password == "" {
+			log.Fatal("ADMIN_PASSWORD is required to create a new account")
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
+		if err != nil {
+			log.Fatal("Error hashing password: ", err)
+		}
+		result, err := tx.Exec(
+			"INSERT INTO Accounts (phone_number, password, status) VALUES (?, ?, 'active')",
+			*phoneNumber, string(hashed),
+		)
+		if err != nil {
+			log.Fatal("Error creating account: ", err)
+		}
+		accountID, _ = result.LastInsertId()
+	} else if err != nil {
+		log.Fatal("Error looking up account: ", err)
+	}
+
+	var isAdmin bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM Admins WHERE account_id = ?)", accountID).Scan(&isAdmin); err != nil {
+		log.Fatal("Error checking admin role: ", err)
+	}
+	if isAdmin {
+		log.Fatal("Account is already an admin")
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO Admins (account_id, phone_number, name) VALUES (?, ?, ?)",
+		accountID, *phoneNumber, *name,
+	)
+	if err != nil {
+		log.Fatal("Error creating admin: ", err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("Error committing admin: ", err)
+	}
+	id, _ := result.LastInsertId()
+	log.Printf("Admin %d created for account %d", id, accountID)
+}
@@ -0,0 +1,164 @@
+// Command normalizephones rewrites the phone numbers stored before normalization into E.164,
+// the form every write path now uses. It only reports by default; pass -apply to write.
+//
+//	go run ./cmd/normalizephones          # dry run
+//	go run ./cmd/normalizephones -apply
+//
+// Accounts whose numbers normalize to the same E.164 number are collisions: they are listed
+// and left untouched so an admin can decide which account keeps the number. The command exits
+// with status 1 when there are collisions or numbers it cannot parse.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"delivery_webservice/config"
+	"delivery_webservice/phone"
+)
+
+type account struct {
+	id           int
+	phone        string
+	pendingPhone sql.NullString
+	normalized   string
+}
+
+func main() {
+	apply := flag.Bool("apply", false, "write the normalized numbers instead of only reporting")
+	flag.Parse()
+
+	config.Connect()
+	db := config.DB
+
+	// Erased accounts hold a placeholder instead of a number
+	rows, err := db.Query("SELECT account_id, phone_number, pending_phone_number FROM Accounts WHERE deleted_at IS NULL ORDER BY account_id")
+	if err != nil {
+		log.Fatal("Error loading accounts: ", err)
+	}
+	var accounts []*account
+	for rows.Next() {
+		a := &account{}
+		if err := rows.Scan(&a.id, &a.phone, &a.pendingPhone); err != nil {
+			log.Fatal("Error scanning account: ", err)
+		}
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal("Error loading accounts: ", err)
+	}
+	rows.Close()
+
+	var invalid []*account
+	byNumber := map[string][]*account{}
+	for _, a := range accounts {
+		normalized, err := phone.Normalize(a.phone)
+		if err != nil {
+			invalid = append(invalid, a)
+			continue
+		}
+		a.normalized = normalized
+		byNumber[normalized] = append(byNumber[normalized], a)
+	}
+
+	var numbers []string
+	for number := range byNumber {
+		numbers = append(numbers, number)
+	}
+	sort.Strings(numbers)
+
+	collisions, changed, failed := 0, 0, 0
+	for _, number := range numbers {
+		group := byNumber[number]
+		if len(group) > 1 {
+			collisions++
+			fmt.Printf("COLLISION %s:", number)
+			for _, a := range group {
+				fmt.Printf(" account %d (%q)", a.id, a.phone)
+			}
+			fmt.Println()
+			continue
+		}
+
+		a := group[0]
+		if a.normalized == a.phone && !needsPendingFix(a) {
+			continue
+		}
+		changed++
+		fmt.Printf("account %d: %q -> %q\n", a.id, a.phone, a.normalized)
+		if *apply {
+			if err := normalizeAccount(db, a); err != nil {
+				failed++
+				log.Printf("Error updating account %d: %v", a.id, err)
+			}
+		}
+	}
+
+	for _, a := range invalid {
+		fmt.Printf("INVALID account %d: %q is not a phone number\n", a.id, a.phone)
+	}
+
+	verb := "would change"
+	if *apply {
+		verb = "changed"
+	}
+	fmt.Printf("%d accounts, %s %d, %d collisions, %d invalid, %d failed\n",
+		len(accounts), verb, changed-failed, collisions, len(invalid), failed)
+	if collisions > 0 || len(invalid) > 0 || failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// needsPendingFix reports whether a phone change waiting for confirmation is not yet in E.164
+func needsPendingFix(a *account) bool {
+	if !a.pendingPhone.Valid {
+		return false
+	}
+	normalized, err := phone.Normalize(a.pendingPhone.String)
+	return err != nil || normalized != a.pendingPhone.String
+}
+
+// normalizeAccount writes the normalized number to the account, its role profiles and the
+// copies kept on its shipments, in one transaction
+func normalizeAccount(db *sql.DB, a *account) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// A pending change that cannot be normalized is dropped; the user can ask again
+	var pending interface{}
+	if a.pendingPhone.Valid {
+		if normalized, err := phone.Normalize(a.pendingPhone.String); err == nil {
+			pending = normalized
+		}
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE Accounts SET phone_number = ?, pending_phone_number = ? WHERE account_id = ? AND phone_number = ?",
+			[]interface{}{a.normalized, pending, a.id, a.phone}},
+		{"UPDATE Users SET phone_number = ? WHERE account_id = ?", []interface{}{a.normalized, a.id}},
+		{"UPDATE Riders SET phone_number = ? WHERE account_id = ?", []interface{}{a.normalized, a.id}},
+		{"UPDATE Admins SET phone_number = ? WHERE account_id = ?", []interface{}{a.normalized, a.id}},
+		{`UPDATE Shipments s JOIN Users u ON u.uid = s.sender_id
+			SET s.sender_phone = ? WHERE u.account_id = ? AND s.sender_phone = ?`, []interface{}{a.normalized, a.id, a.phone}},
+		{`UPDATE Shipments s JOIN Users u ON u.uid = s.receiver_id
+			SET s.receiver_phone = ? WHERE u.account_id = ? AND s.receiver_phone = ?`, []interface{}{a.normalized, a.id, a.phone}},
+		// Codes sent to the old form could never be matched again
+		{"DELETE FROM Otp_Codes WHERE phone_number = ?", []interface{}{a.phone}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
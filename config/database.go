@@ -10,7 +10,7 @@ import (
 var DB *sql.DB
 
 func Connect() {
-	dsn := "web66_65011212243:65011212243@csmsu@tcp(202.28.34.197:3306)/web66_65011212243"
+	dsn := "web66_65011212243:65011212243@csmsu@tcp(202.28.34.197:3306)/web66_65011212243?parseTime=true"
 	var err error
 	DB, err = sql.Open("mysql", dsn)
 	if err != nil {
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// JWTSecret is the HMAC key used to sign access tokens
+var JWTSecret []byte
+
+// AccessTokenTTL is how long an access token stays valid after login
+var AccessTokenTTL = 15 * time.Minute
+
+// RefreshTokenTTL is how long a refresh token can be exchanged before the user must log in again
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+// OTP settings for codes sent by SMS
+var (
+	OTPTTL            = 5 * time.Minute // how long a code can be used
+	OTPResendInterval = time.Minute     // minimum wait between two codes for the same phone
+	OTPMaxPerHour     = 5               // codes per phone and purpose in a rolling hour
+	OTPMaxAttempts    = 5               // wrong guesses before a code is burned
+)
+
+// PendingAccountTTL is how long a new account may stay unverified before it is deleted
+var PendingAccountTTL = 24 * time.Hour
+
+// Login throttling. After the free attempts each failure doubles the wait before the next
+// attempt, and reaching the lockout threshold blocks the phone or IP for LoginLockoutDuration.
+var (
+	LoginFailureWindow     = 15 * time.Minute // failures older than this are forgotten
+	LoginBackoffBase       = time.Second
+	LoginPhoneFreeAttempts = 3
+	LoginPhoneLockoutAt    = 10
+	LoginIPFreeAttempts    = 10
+	LoginIPLockoutAt       = 50
+	LoginLockoutDuration   = 15 * time.Minute
+)
+
+// TrustProxyHeaders makes the client IP come from X-Forwarded-For; only enable behind a trusted proxy
+var TrustProxyHeaders = false
+
+// BcryptCost is the work factor for new password hashes. Stored hashes with a different
+// cost are rehashed the next time their owner logs in.
+var BcryptCost = 12
+
+// Uploaded images. BlobBackend "local" keeps files in UploadDir and serves them at /uploads/,
+// so UploadBaseURL must end in /uploads; "s3" stores them in an S3-compatible bucket such as MinIO.
+var (
+	BlobBackend    = "local"
+	UploadDir      = "uploads"
+	UploadBaseURL  = "http://localhost:8080/uploads"
+	UploadMaxBytes = 5 << 20
+
+	S3Endpoint  = ""
+	S3AccessKey = ""
+	S3SecretKey = ""
+	S3Bucket    = "delivery-uploads"
+	S3UseSSL    = true
+	S3PublicURL = "" // where clients fetch objects; defaults to the endpoint and bucket
+)
+
+// Service area for locations sent by clients. The defaults cover Thailand.
+var (
+	GeoMinLat = 5.6
+	GeoMaxLat = 20.5
+	GeoMinLng = 97.3
+	GeoMaxLng = 105.7
+)
+
+// Rider job board. Riders search within JobBoardRadiusKm of their position unless they ask
+// for another radius, up to JobBoardMaxRadiusKm.
+var (
+	JobBoardRadiusKm    = 5.0
+	JobBoardMaxRadiusKm = 30.0
+)
+
+// RiderMaxActiveJobs is how many shipments a rider may hold at once, from acceptance to delivery
+var RiderMaxActiveJobs = 1
+
+// LoadSettings reads runtime settings from environment variables
+func LoadSettings() {
+	secret := os.Getenv("JWT_SECRET")
+	if len(secret) < 32 {
+		log.Fatal("JWT_SECRET must be set to at least 32 characters")
+	}
+	JWTSecret = []byte(secret)
+
+	AccessTokenTTL = getDuration("ACCESS_TOKEN_TTL", AccessTokenTTL)
+	RefreshTokenTTL = getDuration("REFRESH_TOKEN_TTL", RefreshTokenTTL)
+
+	OTPTTL = getDuration("OTP_TTL", OTPTTL)
+	OTPResendInterval = getDuration("OTP_RESEND_INTERVAL", OTPResendInterval)
+	OTPMaxPerHour = getInt("OTP_MAX_PER_HOUR", OTPMaxPerHour)
+	OTPMaxAttempts = getInt("OTP_MAX_ATTEMPTS", OTPMaxAttempts)
+
+	PendingAccountTTL = getDuration("PENDING_ACCOUNT_TTL", PendingAccountTTL)
+
+	LoginFailureWindow = getDuration("LOGIN_FAILURE_WINDOW", LoginFailureWindow)
+	LoginBackoffBase = getDuration("LOGIN_BACKOFF_BASE", LoginBackoffBase)
+	LoginPhoneFreeAttempts = getInt("LOGIN_PHONE_FREE_ATTEMPTS", LoginPhoneFreeAttempts)
+	LoginPhoneLockoutAt = getInt("LOGIN_PHONE_LOCKOUT_AT", LoginPhoneLockoutAt)
+	LoginIPFreeAttempts = getInt("LOGIN_IP_FREE_ATTEMPTS", LoginIPFreeAttempts)
+	LoginIPLockoutAt = getInt("LOGIN_IP_LOCKOUT_AT", LoginIPLockoutAt)
+	LoginLockoutDuration = getDuration("LOGIN_LOCKOUT_DURATION", LoginLockoutDuration)
+	TrustProxyHeaders = getBool("TRUST_PROXY_HEADERS", TrustProxyHeaders)
+
+	BlobBackend = getString("BLOB_BACKEND", BlobBackend)
+	UploadDir = getString("UPLOAD_DIR", UploadDir)
+	UploadBaseURL = getString("UPLOAD_BASE_URL", UploadBaseURL)
+	UploadMaxBytes = getInt("UPLOAD_MAX_BYTES", UploadMaxBytes)
+	S3Endpoint = getString("S3_ENDPOINT", S3Endpoint)
+	S3AccessKey = getString("S3_ACCESS_KEY", S3AccessKey)
+	S3SecretKey = getString("S3_SECRET_KEY", S3SecretKey)
+	S3Bucket = getString("S3_BUCKET", S3Bucket)
+	S3UseSSL = getBool("S3_USE_SSL", S3UseSSL)
+	S3PublicURL = getString("S3_PUBLIC_URL", S3PublicURL)
+	if BlobBackend != "local" && BlobBackend != "s3" {
+		log.Fatal("BLOB_BACKEND must be local or s3")
+	}
+	if BlobBackend == "s3" && S3Endpoint == "" {
+		log.Fatal("S3_ENDPOINT must be set when BLOB_BACKEND is s3")
+	}
+
+	GeoMinLat = getFloat("GEO_MIN_LAT", GeoMinLat)
+	GeoMaxLat = getFloat("GEO_MAX_LAT", GeoMaxLat)
+	GeoMinLng = getFloat("GEO_MIN_LNG", GeoMinLng)
+	GeoMaxLng = getFloat("GEO_MAX_LNG", GeoMaxLng)
+	if GeoMinLat >= GeoMaxLat || GeoMinLng >= GeoMaxLng || GeoMinLat < -90 || GeoMaxLat > 90 || GeoMinLng < -180 || GeoMaxLng > 180 {
+		log.Fatal("GEO_MIN_LAT/GEO_MAX_LAT/GEO_MIN_LNG/GEO_MAX_LNG do not describe a valid area")
+	}
+
+	JobBoardRadiusKm = getFloat("JOB_BOARD_RADIUS_KM", JobBoardRadiusKm)
+	JobBoardMaxRadiusKm = getFloat("JOB_BOARD_MAX_RADIUS_KM", JobBoardMaxRadiusKm)
+	if JobBoardRadiusKm <= 0 || JobBoardMaxRadiusKm < JobBoardRadiusKm {
+		log.Fatal("JOB_BOARD_RADIUS_KM must be positive and at most JOB_BOARD_MAX_RADIUS_KM")
+	}
+	RiderMaxActiveJobs = getInt("RIDER_MAX_ACTIVE_JOBS", RiderMaxActiveJobs)
+	if RiderMaxActiveJobs < 1 {
+		log.Fatal("RIDER_MAX_ACTIVE_JOBS must be at least 1")
+	}
+
+	BcryptCost = getInt("BCRYPT_COST", BcryptCost)
+	if BcryptCost < bcrypt.MinCost || BcryptCost > bcrypt.MaxCost {
+		log.Fatalf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+}
+
+// getString reads a string from the environment, falling back to def
+func getString(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getDuration reads a duration such as "15m" from the environment, falling back to def
+func getDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid duration for %s: %v", key, err)
+	}
+	return d
+}
+
+// getInt reads an integer from the environment, falling back to def
+func getInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Invalid integer for %s: %v", key, err)
+	}
+	return n
+}
+
+// getFloat reads a decimal number from the environment, falling back to def
+func getFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("Invalid number for %s: %v", key, err)
+	}
+	return f
+}
+
+// getBool reads a boolean such as "true" or "1" from the environment, falling back to def
+func getBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("Invalid boolean for %s: %v", key, err)
+	}
+	return b
+}
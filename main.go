@@ -1,18 +1,44 @@
 package main
 
 import (
+    "context"
     "log"
     "net/http"
+    "time"
+
+    "delivery_webservice/api"
     "delivery_webservice/config" // Import the config package
+    "delivery_webservice/notify"
     "delivery_webservice/router"  // Import the router package
+    "delivery_webservice/storage"
 )
 
 func main() {
+    // Load runtime settings such as the token signing key
+    config.LoadSettings()
+
     // Initialize database connection using the config package
     config.Connect()
 
+    // Delete accounts that were never verified
+    api.StartPendingAccountCleanup(context.Background(), config.DB, time.Hour)
+
+    // SMS messages are written to the log until a real gateway is configured
+    sms := notify.LogSMSSender{}
+
+    // Uploaded images go to local disk or an S3-compatible bucket
+    var store storage.BlobStore = &storage.LocalBlobStore{Dir: config.UploadDir, BaseURL: config.UploadBaseURL}
+    if config.BlobBackend == "s3" {
+        s3, err := storage.NewS3BlobStore(context.Background(), config.S3Endpoint, config.S3AccessKey,
+            config.S3SecretKey, config.S3Bucket, config.S3UseSSL, config.S3PublicURL)
+        if err != nil {
+            log.Fatal("Error connecting to object storage: ", err)
+        }
+        store = s3
+    }
+
     // Initialize the router with the database connection from the config package
-    r := router.InitRoutes(config.DB)
+    r := router.InitRoutes(config.DB, sms, store)
 
     // Start the server
     log.Fatal(http.ListenAndServe(":8080", r))
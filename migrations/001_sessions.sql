@@ -0,0 +1,22 @@
+-- Login sessions and rotating refresh tokens.
+-- Each row in Sessions is one token family (one login on one device);
+-- every refresh creates a new row in Refresh_Tokens for the same session.
+
+CREATE TABLE Sessions (
+    sid          CHAR(32)    NOT NULL PRIMARY KEY,
+    account_type VARCHAR(16) NOT NULL, -- 'user' (Users.uid) or 'rider' (Riders.rid)
+    account_id   INT         NOT NULL,
+    created_at   DATETIME    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at   DATETIME    NULL,
+    INDEX idx_sessions_account (account_type, account_id)
+);
+
+CREATE TABLE Refresh_Tokens (
+    token_hash CHAR(64) NOT NULL PRIMARY KEY, -- SHA-256 of the token, hex encoded
+    session_id CHAR(32) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    rotated_at DATETIME NULL,                 -- set once the token has been exchanged
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_refresh_tokens_session (session_id),
+    FOREIGN KEY (session_id) REFERENCES Sessions (sid) ON DELETE CASCADE
+);
@@ -0,0 +1,10 @@
+-- Operations staff. Admin accounts log in through /api/auth/login like users and riders
+-- and are created with `go run ./cmd/createadmin`.
+
+CREATE TABLE Admins (
+    aid          INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    phone_number VARCHAR(20)  NOT NULL UNIQUE,
+    password     VARCHAR(255) NOT NULL,
+    name         VARCHAR(255) NOT NULL,
+    created_at   DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
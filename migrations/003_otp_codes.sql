@@ -0,0 +1,14 @@
+-- One-time codes sent by SMS, e.g. for password reset.
+-- Codes are stored as an HMAC so a database leak does not reveal them.
+
+CREATE TABLE Otp_Codes (
+    id           INT         NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    phone_number VARCHAR(20) NOT NULL,
+    purpose      VARCHAR(32) NOT NULL, -- e.g. 'password_reset'
+    code_hash    CHAR(64)    NOT NULL,
+    attempts     INT         NOT NULL DEFAULT 0,
+    expires_at   DATETIME    NOT NULL,
+    consumed_at  DATETIME    NULL,
+    created_at   DATETIME    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_otp_codes_phone (phone_number, purpose, created_at)
+);
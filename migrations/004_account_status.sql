@@ -0,0 +1,11 @@
+-- Accounts start as 'pending' until the phone number is verified with an SMS code.
+-- Pending accounts that are not verified before verify_expires_at are deleted.
+-- Existing accounts are treated as verified.
+
+ALTER TABLE Users
+    ADD COLUMN status            VARCHAR(16) NOT NULL DEFAULT 'active',
+    ADD COLUMN verify_expires_at DATETIME    NULL;
+
+ALTER TABLE Riders
+    ADD COLUMN status            VARCHAR(16) NOT NULL DEFAULT 'active',
+    ADD COLUMN verify_expires_at DATETIME    NULL;
@@ -0,0 +1,12 @@
+-- Lockouts triggered by repeated failed logins, kept for support staff.
+
+CREATE TABLE Login_Lockouts (
+    id           INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    key_type     VARCHAR(16)  NOT NULL, -- 'phone' or 'ip'
+    key_value    VARCHAR(64)  NOT NULL,
+    failures     INT          NOT NULL,
+    locked_until DATETIME     NOT NULL,
+    created_at   DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_login_lockouts_key (key_type, key_value),
+    INDEX idx_login_lockouts_created (created_at)
+);
@@ -0,0 +1,69 @@
+-- One login identity per phone number. Users, Riders and Admins become role
+-- profiles that point at an account, so one phone can be both a sender and a rider.
+-- Passwords and verification status move from the role tables to Accounts.
+
+CREATE TABLE Accounts (
+    account_id        INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    phone_number      VARCHAR(20)  NOT NULL UNIQUE,
+    password          VARCHAR(255) NOT NULL,
+    status            VARCHAR(16)  NOT NULL DEFAULT 'active', -- 'pending' until the phone is verified
+    verify_expires_at DATETIME     NULL,
+    created_at        DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Riders first: login used to prefer the Riders row, so that is the password people use today
+INSERT INTO Accounts (phone_number, password, status, verify_expires_at)
+SELECT phone_number, password, status, verify_expires_at FROM Riders;
+
+INSERT INTO Accounts (phone_number, password, status, verify_expires_at)
+SELECT u.phone_number, u.password, u.status, u.verify_expires_at
+FROM Users u
+WHERE NOT EXISTS (SELECT 1 FROM Accounts a WHERE a.phone_number = u.phone_number);
+
+INSERT INTO Accounts (phone_number, password)
+SELECT d.phone_number, d.password
+FROM Admins d
+WHERE NOT EXISTS (SELECT 1 FROM Accounts a WHERE a.phone_number = d.phone_number);
+
+-- Link the role tables to their account
+ALTER TABLE Users ADD COLUMN account_id INT NULL;
+UPDATE Users u JOIN Accounts a ON a.phone_number = u.phone_number SET u.account_id = a.account_id;
+ALTER TABLE Users
+    MODIFY account_id INT NOT NULL,
+    ADD UNIQUE INDEX uq_users_account (account_id),
+    ADD FOREIGN KEY (account_id) REFERENCES Accounts (account_id),
+    MODIFY password VARCHAR(255) NULL,
+    DROP COLUMN status,
+    DROP COLUMN verify_expires_at;
+UPDATE Users SET password = NULL;
+
+ALTER TABLE Riders ADD COLUMN account_id INT NULL;
+UPDATE Riders r JOIN Accounts a ON a.phone_number = r.phone_number SET r.account_id = a.account_id;
+ALTER TABLE Riders
+    MODIFY account_id INT NOT NULL,
+    ADD UNIQUE INDEX uq_riders_account (account_id),
+    ADD FOREIGN KEY (account_id) REFERENCES Accounts (account_id),
+    MODIFY password VARCHAR(255) NULL,
+    DROP COLUMN status,
+    DROP COLUMN verify_expires_at;
+UPDATE Riders SET password = NULL;
+
+ALTER TABLE Admins ADD COLUMN account_id INT NULL;
+UPDATE Admins d JOIN Accounts a ON a.phone_number = d.phone_number SET d.account_id = a.account_id;
+ALTER TABLE Admins
+    MODIFY account_id INT NOT NULL,
+    ADD UNIQUE INDEX uq_admins_account (account_id),
+    ADD FOREIGN KEY (account_id) REFERENCES Accounts (account_id),
+    MODIFY password VARCHAR(255) NULL;
+UPDATE Admins SET password = NULL;
+
+-- Sessions.account_id held a uid, rid or aid; it now holds Accounts.account_id.
+-- account_type becomes the role the session is currently acting as.
+UPDATE Sessions s JOIN Users u ON s.account_type = 'user' AND u.uid = s.account_id SET s.account_id = u.account_id;
+UPDATE Sessions s JOIN Riders r ON s.account_type = 'rider' AND r.rid = s.account_id SET s.account_id = r.account_id;
+UPDATE Sessions s JOIN Admins d ON s.account_type = 'admin' AND d.aid = s.account_id SET s.account_id = d.account_id;
+ALTER TABLE Sessions
+    CHANGE account_type active_role VARCHAR(16) NOT NULL,
+    DROP INDEX idx_sessions_account,
+    ADD INDEX idx_sessions_account (account_id),
+    ADD FOREIGN KEY (account_id) REFERENCES Accounts (account_id);
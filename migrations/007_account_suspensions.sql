@@ -0,0 +1,21 @@
+-- Admins can suspend and reinstate accounts. Suspended accounts cannot log in or
+-- use any authenticated route; shipments of a suspended rider are flagged.
+
+ALTER TABLE Accounts
+    ADD COLUMN suspended_at      DATETIME     NULL,
+    ADD COLUMN suspension_reason VARCHAR(500) NULL;
+
+-- Every suspend and reinstate action, with the admin who took it
+CREATE TABLE Account_Suspensions (
+    id         INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    account_id INT          NOT NULL,
+    action     VARCHAR(16)  NOT NULL, -- 'suspend' or 'reinstate'
+    reason     VARCHAR(500) NOT NULL,
+    admin_id   INT          NOT NULL, -- Admins.aid
+    created_at DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_account_suspensions_account (account_id),
+    FOREIGN KEY (account_id) REFERENCES Accounts (account_id)
+);
+
+ALTER TABLE Shipments
+    ADD COLUMN rider_suspended_at DATETIME NULL;
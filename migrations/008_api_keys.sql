@@ -0,0 +1,20 @@
+-- API keys let merchant backends call the delivery endpoints on behalf of a sender account.
+-- Only the SHA-256 of a key is stored; the key itself is shown once when it is issued.
+
+CREATE TABLE Api_Keys (
+    id            INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    key_prefix    VARCHAR(16)  NOT NULL,          -- first characters of the key, to tell keys apart
+    key_hash      CHAR(64)     NOT NULL UNIQUE,
+    account_id    INT          NOT NULL,
+    user_id       INT          NOT NULL,          -- Users.uid the key acts as
+    name          VARCHAR(100) NOT NULL,
+    scopes        VARCHAR(255) NOT NULL,          -- comma separated, e.g. 'deliveries:create,deliveries:read'
+    created_by    INT          NOT NULL,          -- Admins.aid
+    created_at    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at    DATETIME     NULL,
+    last_used_at  DATETIME     NULL,
+    request_count BIGINT       NOT NULL DEFAULT 0,
+    INDEX idx_api_keys_account (account_id),
+    FOREIGN KEY (account_id) REFERENCES Accounts (account_id),
+    FOREIGN KEY (user_id) REFERENCES Users (uid)
+);
@@ -0,0 +1,21 @@
+-- Append-only record of authentication and account events.
+-- The triggers stop the application (or anyone else) from rewriting history.
+
+CREATE TABLE Audit_Log (
+    id               BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    event            VARCHAR(64)  NOT NULL,  -- e.g. 'login.success', 'admin.account.suspend'
+    account_id       INT          NULL,      -- account the event is about
+    actor_account_id INT          NULL,      -- account that caused it, when different (admin actions)
+    ip               VARCHAR(64)  NOT NULL,
+    user_agent       VARCHAR(255) NOT NULL,
+    details          JSON         NULL,
+    created_at       DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_audit_log_account (account_id, created_at),
+    INDEX idx_audit_log_created (created_at)
+);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON Audit_Log
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Audit_Log is append-only';
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON Audit_Log
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Audit_Log is append-only';
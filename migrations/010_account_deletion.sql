@@ -0,0 +1,5 @@
+-- Accounts erased at the owner's request (PDPA). The row is kept, anonymized, so that
+-- shipments of other people that reference its Users/Riders profiles stay intact.
+
+ALTER TABLE Accounts
+    ADD COLUMN deleted_at DATETIME NULL;
@@ -0,0 +1,8 @@
+-- Device details for each login so people can see and revoke where they are signed in.
+
+ALTER TABLE Sessions
+    ADD COLUMN device_name  VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN platform     VARCHAR(32)  NOT NULL DEFAULT '', -- e.g. 'android', 'ios', 'web'
+    ADD COLUMN ip           VARCHAR(64)  NOT NULL DEFAULT '',
+    ADD COLUMN user_agent   VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at DATETIME     NULL;
@@ -0,0 +1,27 @@
+-- A phone number change waits here until the new number is confirmed by SMS code
+ALTER TABLE Accounts
+    ADD COLUMN pending_phone_number     VARCHAR(20) NULL,
+    ADD COLUMN pending_phone_expires_at DATETIME    NULL;
+
+-- Shipments keep a copy of the sender and receiver details as they were when the
+-- shipment was created, so later profile edits do not change past deliveries.
+ALTER TABLE Shipments
+    ADD COLUMN sender_name           VARCHAR(255) NULL,
+    ADD COLUMN sender_phone          VARCHAR(20)  NULL,
+    ADD COLUMN sender_address        VARCHAR(255) NULL,
+    ADD COLUMN sender_gps_location   POINT        NULL,
+    ADD COLUMN receiver_name         VARCHAR(255) NULL,
+    ADD COLUMN receiver_phone        VARCHAR(20)  NULL,
+    ADD COLUMN receiver_address      VARCHAR(255) NULL,
+    ADD COLUMN receiver_gps_location POINT        NULL;
+
+-- Existing shipments get the profile details as they are today, the best record we have
+UPDATE Shipments s
+JOIN Users u ON u.uid = s.sender_id
+SET s.sender_name = u.name, s.sender_phone = u.phone_number,
+    s.sender_address = u.address, s.sender_gps_location = u.gps_location;
+
+UPDATE Shipments s
+JOIN Users u ON u.uid = s.receiver_id
+SET s.receiver_name = u.name, s.receiver_phone = u.phone_number,
+    s.receiver_address = u.address, s.receiver_gps_location = u.gps_location;
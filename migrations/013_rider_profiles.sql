@@ -0,0 +1,21 @@
+-- Vehicle details shown to senders alongside the license plate
+ALTER TABLE Riders
+    ADD COLUMN vehicle_type  VARCHAR(16) NULL, -- 'motorcycle', 'car', 'pickup' or 'bicycle'
+    ADD COLUMN vehicle_model VARCHAR(64) NULL,
+    ADD COLUMN vehicle_color VARCHAR(32) NULL;
+
+-- One rating per delivered shipment, given by its sender
+CREATE TABLE Rider_Ratings (
+    id          INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    shipment_id INT          NOT NULL UNIQUE,
+    rid         INT          NOT NULL,
+    uid         INT          NOT NULL,
+    rating      TINYINT      NOT NULL,
+    comment     VARCHAR(500) NULL,
+    created_at  DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_rider_ratings_rider (rid),
+    CHECK (rating BETWEEN 1 AND 5),
+    FOREIGN KEY (shipment_id) REFERENCES Shipments (shipments),
+    FOREIGN KEY (rid) REFERENCES Riders (rid),
+    FOREIGN KEY (uid) REFERENCES Users (uid)
+);
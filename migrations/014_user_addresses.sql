@@ -0,0 +1,37 @@
+-- Saved addresses, several per user. location is POINT(lng lat): x is longitude, y is latitude.
+-- default_uid is only set on the default address, so the unique index allows one default per user.
+CREATE TABLE User_Addresses (
+    id           INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    uid          INT          NOT NULL,
+    label        VARCHAR(50)  NOT NULL, -- e.g. 'Home', 'Office', 'Shop'
+    address      VARCHAR(255) NOT NULL,
+    location     POINT        NOT NULL,
+    contact_name VARCHAR(255) NOT NULL DEFAULT '',
+    rider_notes  VARCHAR(500) NOT NULL DEFAULT '',
+    is_default   BOOLEAN      NOT NULL DEFAULT FALSE,
+    default_uid  INT AS (IF(is_default, uid, NULL)) STORED,
+    created_at   DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at   DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_user_addresses_user (uid),
+    UNIQUE INDEX uq_user_addresses_default (default_uid),
+    FOREIGN KEY (uid) REFERENCES Users (uid)
+);
+
+-- The single profile address becomes each user's default saved address
+INSERT INTO User_Addresses (uid, label, address, location, contact_name, is_default)
+SELECT uid, 'Home', COALESCE(address, ''), gps_location, name, TRUE
+FROM Users
+WHERE gps_location IS NOT NULL;
+
+-- Shipments remember which saved addresses they were created from. The address text and
+-- location are copied into the sender_/receiver_ columns, so editing or deleting a saved
+-- address does not change the shipment.
+ALTER TABLE Shipments
+    ADD COLUMN pickup_address_id    INT          NULL,
+    ADD COLUMN pickup_contact_name  VARCHAR(255) NULL,
+    ADD COLUMN pickup_notes         VARCHAR(500) NULL,
+    ADD COLUMN dropoff_address_id   INT          NULL,
+    ADD COLUMN dropoff_contact_name VARCHAR(255) NULL,
+    ADD COLUMN dropoff_notes        VARCHAR(500) NULL,
+    ADD FOREIGN KEY (pickup_address_id) REFERENCES User_Addresses (id) ON DELETE SET NULL,
+    ADD FOREIGN KEY (dropoff_address_id) REFERENCES User_Addresses (id) ON DELETE SET NULL;
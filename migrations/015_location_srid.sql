@@ -0,0 +1,48 @@
+-- Locations become WGS 84 points (SRID 4326). MySQL's axis order for SRID 4326 is latitude first,
+-- so the stored point's first coordinate is the latitude. ST_SRID only relabels a point, so the
+-- rewrites below pass the latitude first; the app writes points with
+-- ST_PointFromText(..., 'axis-order=long-lat') and reads them with ST_Latitude and ST_Longitude.
+-- Older rows were written from client WKT in either order. Inside the service area
+-- (Thailand) latitude is always smaller than longitude, so the smaller coordinate is the
+-- latitude. Points that are not valid coordinates either way are dropped.
+
+UPDATE Users SET gps_location = NULL
+WHERE gps_location IS NOT NULL
+  AND NOT (LEAST(ST_X(gps_location), ST_Y(gps_location)) BETWEEN -90 AND 90
+       AND GREATEST(ST_X(gps_location), ST_Y(gps_location)) BETWEEN -180 AND 180);
+UPDATE Users
+SET gps_location = ST_SRID(POINT(LEAST(ST_X(gps_location), ST_Y(gps_location)),
+                                GREATEST(ST_X(gps_location), ST_Y(gps_location))), 4326)
+WHERE gps_location IS NOT NULL;
+ALTER TABLE Users MODIFY gps_location POINT NULL SRID 4326;
+
+-- Saved addresses need a location, so unusable ones are removed rather than emptied
+DELETE FROM User_Addresses
+WHERE NOT (LEAST(ST_X(location), ST_Y(location)) BETWEEN -90 AND 90
+       AND GREATEST(ST_X(location), ST_Y(location)) BETWEEN -180 AND 180);
+UPDATE User_Addresses
+SET location = ST_SRID(POINT(LEAST(ST_X(location), ST_Y(location)),
+                            GREATEST(ST_X(location), ST_Y(location))), 4326);
+ALTER TABLE User_Addresses MODIFY location POINT NOT NULL SRID 4326;
+
+UPDATE Shipments SET sender_gps_location = NULL
+WHERE sender_gps_location IS NOT NULL
+  AND NOT (LEAST(ST_X(sender_gps_location), ST_Y(sender_gps_location)) BETWEEN -90 AND 90
+       AND GREATEST(ST_X(sender_gps_location), ST_Y(sender_gps_location)) BETWEEN -180 AND 180);
+UPDATE Shipments
+SET sender_gps_location = ST_SRID(POINT(LEAST(ST_X(sender_gps_location), ST_Y(sender_gps_location)),
+                                       GREATEST(ST_X(sender_gps_location), ST_Y(sender_gps_location))), 4326)
+WHERE sender_gps_location IS NOT NULL;
+
+UPDATE Shipments SET receiver_gps_location = NULL
+WHERE receiver_gps_location IS NOT NULL
+  AND NOT (LEAST(ST_X(receiver_gps_location), ST_Y(receiver_gps_location)) BETWEEN -90 AND 90
+       AND GREATEST(ST_X(receiver_gps_location), ST_Y(receiver_gps_location)) BETWEEN -180 AND 180);
+UPDATE Shipments
+SET receiver_gps_location = ST_SRID(POINT(LEAST(ST_X(receiver_gps_location), ST_Y(receiver_gps_location)),
+                                         GREATEST(ST_X(receiver_gps_location), ST_Y(receiver_gps_location))), 4326)
+WHERE receiver_gps_location IS NOT NULL;
+
+ALTER TABLE Shipments
+    MODIFY sender_gps_location   POINT NULL SRID 4326,
+    MODIFY receiver_gps_location POINT NULL SRID 4326;
@@ -0,0 +1,33 @@
+-- Riders must be approved by an admin, from their uploaded documents, before they can take jobs.
+-- Existing riders start as pending too: the documents are a legal requirement for everyone.
+ALTER TABLE Riders
+    ADD COLUMN verification_status       VARCHAR(16)  NOT NULL DEFAULT 'pending', -- 'pending', 'approved' or 'rejected'
+    ADD COLUMN verification_reason       VARCHAR(500) NULL, -- why the last review rejected the rider
+    ADD COLUMN verification_submitted_at DATETIME     NULL, -- set while waiting in the review queue
+    ADD COLUMN verified_at               DATETIME     NULL,
+    ADD INDEX idx_riders_verification (verification_status, verification_submitted_at);
+
+-- The latest file of each required document. Files are kept under the private/ prefix of the blob store.
+CREATE TABLE Rider_Documents (
+    id           INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    rid          INT          NOT NULL,
+    doc_type     VARCHAR(32)  NOT NULL, -- 'driving_licence', 'vehicle_registration' or 'national_id'
+    object_key   VARCHAR(255) NOT NULL,
+    content_type VARCHAR(64)  NOT NULL,
+    size         INT          NOT NULL,
+    uploaded_at  DATETIME     NOT NULL,
+    UNIQUE INDEX uq_rider_documents_type (rid, doc_type),
+    FOREIGN KEY (rid) REFERENCES Riders (rid)
+);
+
+-- Every approve and reject decision, with the admin who made it
+CREATE TABLE Rider_Verification_Reviews (
+    id         INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    rid        INT          NOT NULL,
+    decision   VARCHAR(16)  NOT NULL, -- 'approved' or 'rejected'
+    reason     VARCHAR(500) NOT NULL,
+    admin_id   INT          NOT NULL, -- Admins.aid
+    created_at DATETIME     NOT NULL,
+    INDEX idx_rider_verification_reviews_rider (rid),
+    FOREIGN KEY (rid) REFERENCES Riders (rid)
+);
@@ -0,0 +1,20 @@
+-- Shipments.status now follows a state machine: 1 waiting, 2 rider assigned, 3 picked up,
+-- 4 in transit, 5 delivered, 6 cancelled, 7 failed. Existing rows are all 1 (waiting), the only
+-- status written before this migration.
+ALTER TABLE Shipments
+    ADD COLUMN status_updated_at DATETIME NULL,
+    ADD INDEX idx_shipments_status (status);
+
+-- Every status change, with who made it. from_status is NULL for the row written when a shipment is created.
+CREATE TABLE Shipment_Status_History (
+    id               INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    shipment_id      INT          NOT NULL,
+    from_status      TINYINT      NULL,
+    to_status        TINYINT      NOT NULL,
+    actor_account_id INT          NOT NULL,
+    actor_role       VARCHAR(16)  NOT NULL, -- 'user', 'rider' or 'admin'
+    reason           VARCHAR(500) NULL, -- required for cancelled and failed
+    created_at       DATETIME     NOT NULL,
+    INDEX idx_shipment_status_history_shipment (shipment_id, id),
+    FOREIGN KEY (shipment_id) REFERENCES Shipments (shipments)
+);
@@ -0,0 +1,16 @@
+-- Pickup points of shipments waiting for a rider, for the rider job board. MySQL only builds
+-- spatial indexes on NOT NULL columns, and Shipments.sender_gps_location may be NULL, so the
+-- waiting shipments that have a pickup point are kept here. Rows are added when a shipment
+-- becomes waiting and removed when it leaves that status.
+CREATE TABLE Job_Board (
+    shipment_id     INT      NOT NULL PRIMARY KEY,
+    pickup_location POINT    NOT NULL SRID 4326,
+    created_at      DATETIME NOT NULL,
+    SPATIAL INDEX idx_job_board_pickup (pickup_location),
+    FOREIGN KEY (shipment_id) REFERENCES Shipments (shipments)
+);
+
+INSERT INTO Job_Board (shipment_id, pickup_location, created_at)
+SELECT shipments, sender_gps_location, COALESCE(status_updated_at, UTC_TIMESTAMP())
+FROM Shipments
+WHERE status = 1 AND sender_gps_location IS NOT NULL;
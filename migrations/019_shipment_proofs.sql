@@ -0,0 +1,17 @@
+-- Photo and rider position taken when a shipment is picked up and when it is delivered. A shipment
+-- reaches each of those statuses at most once, so it has at most one proof per stage. Photos are kept
+-- under the private/ prefix of the blob store. Erasing the sender's or receiver's account removes the
+-- photo and the position but keeps the row, so the shipment still shows when the proof was taken.
+CREATE TABLE Shipment_Proofs (
+    id           INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    shipment_id  INT          NOT NULL,
+    stage        VARCHAR(16)  NOT NULL, -- 'pickup' or 'delivery'
+    object_key   VARCHAR(255) NULL,
+    content_type VARCHAR(64)  NULL,
+    size         INT          NULL,
+    location     POINT        NULL SRID 4326,
+    rider_id     INT          NOT NULL, -- Riders.rid
+    captured_at  DATETIME     NOT NULL, -- same time as the status change it proves
+    UNIQUE INDEX uq_shipment_proofs_stage (shipment_id, stage),
+    FOREIGN KEY (shipment_id) REFERENCES Shipments (shipments)
+);
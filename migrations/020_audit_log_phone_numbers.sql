@@ -0,0 +1,12 @@
+-- Audit details no longer carry phone numbers, which would outlive account erasure in the
+-- append-only log; new entries use a keyed hash instead. Remove the numbers from older entries,
+-- lifting the append-only triggers for this one statement.
+
+DROP TRIGGER audit_log_no_update;
+
+UPDATE Audit_Log
+SET details = JSON_REMOVE(details, '$.phone_number', '$.old_phone', '$.new_phone')
+WHERE JSON_CONTAINS_PATH(details, 'one', '$.phone_number', '$.old_phone', '$.new_phone');
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON Audit_Log
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Audit_Log is append-only';
@@ -0,0 +1,10 @@
+-- Every file stored through /api/uploads, with the account that uploaded it, so erasing the account
+-- can remove its profile and item photos from the blob store too.
+CREATE TABLE Uploads (
+    object_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    account_id INT          NOT NULL,
+    purpose    VARCHAR(16)  NOT NULL, -- 'profile' or 'item'
+    created_at DATETIME     NOT NULL,
+    INDEX idx_uploads_account (account_id),
+    FOREIGN KEY (account_id) REFERENCES Accounts (account_id)
+);
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// SMSSender delivers text messages to a phone number
+type SMSSender interface {
+	Send(ctx context.Context, phone, message string) error
+}
+
+// LogSMSSender writes messages to the server log instead of sending them; use it in development
+type LogSMSSender struct{}
+
+func (LogSMSSender) Send(ctx context.Context, phone, message string) error {
+	log.Printf("SMS to %s: %s", phone, message)
+	return nil
+}
+
+// SMS is a message captured by MemorySMSSender
+type SMS struct {
+	Phone   string
+	Message string
+}
+
+// MemorySMSSender keeps every message in memory so tests can read them back
+type MemorySMSSender struct {
+	mu       sync.Mutex
+	messages []SMS
+}
+
+func (m *MemorySMSSender) Send(ctx context.Context, phone, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, SMS{Phone: phone, Message: message})
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far
+func (m *MemorySMSSender) Messages() []SMS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SMS(nil), m.messages...)
+}
+
+// Last returns the most recent message sent to phone
+func (m *MemorySMSSender) Last(phone string) (SMS, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].Phone == phone {
+			return m.messages[i], true
+		}
+	}
+	return SMS{}, false
+}
@@ -0,0 +1,94 @@
+// Package phone turns phone numbers typed in any common format into E.164, the single
+// form stored in the database, e.g. "081-234-5678" and "+66 81 234 5678" both become "+66812345678".
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// DefaultCountryCode is assumed for numbers written in national form with a leading 0
+const DefaultCountryCode = "66"
+
+// ErrInvalid is returned for input that is not a phone number
+var ErrInvalid = errors.New("invalid phone number")
+
+// Normalize returns s in E.164 form. Accepted inputs are Thai national numbers ("0812345678"),
+// numbers with a country code ("+66812345678", "66812345678", "0066812345678") and any of these
+// with spaces, dashes, dots or parentheses between the digits.
+func Normalize(s string) (string, error) {
+	digits, plus, ok := clean(s)
+	if !ok {
+		return "", ErrInvalid
+	}
+
+	switch {
+	case plus:
+	case strings.HasPrefix(digits, "00"):
+		digits = digits[2:]
+	case strings.HasPrefix(digits, "0"):
+		digits = DefaultCountryCode + digits[1:]
+	case strings.HasPrefix(digits, DefaultCountryCode) && len(digits) >= 10 && len(digits) <= 12:
+		// Country code written without "+", possibly followed by the trunk 0
+	default:
+		return "", ErrInvalid
+	}
+
+	// People often keep the trunk 0 after the country code, as in +66 081 234 5678
+	if strings.HasPrefix(digits, DefaultCountryCode+"0") {
+		digits = DefaultCountryCode + digits[len(DefaultCountryCode)+1:]
+	}
+
+	if digits == "" || digits[0] == '0' || len(digits) < 8 || len(digits) > 15 {
+		return "", ErrInvalid
+	}
+	// Thai numbers have 8 digits (landline) or 9 digits (mobile) after the country code
+	if strings.HasPrefix(digits, DefaultCountryCode) {
+		if n := len(digits) - len(DefaultCountryCode); n != 8 && n != 9 {
+			return "", ErrInvalid
+		}
+	}
+	return "+" + digits, nil
+}
+
+// SearchPrefix turns the start of a phone number, as typed into a search box, into the start of
+// the E.164 form so it can be matched with LIKE. It returns "" when s cannot start a number.
+func SearchPrefix(s string) string {
+	digits, plus, ok := clean(s)
+	if !ok || digits == "" {
+		return ""
+	}
+	switch {
+	case plus:
+		return "+" + digits
+	case strings.HasPrefix(digits, "00"):
+		return "+" + digits[2:]
+	case strings.HasPrefix(digits, "0"):
+		return "+" + DefaultCountryCode + digits[1:]
+	case strings.HasPrefix(digits, DefaultCountryCode+"0"):
+		return "+" + DefaultCountryCode + digits[len(DefaultCountryCode)+1:]
+	}
+	return "+" + digits
+}
+
+// clean drops separators and reports the digits, whether the number started with "+", and
+// whether anything other than digits and separators was found
+func clean(s string) (string, bool, bool) {
+	s = strings.TrimSpace(s)
+	plus := strings.HasPrefix(s, "+")
+	if plus {
+		s = s[1:]
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", false, false
+		}
+	}
+	return b.String(), plus, true
+}
@@ -0,0 +1,70 @@
+package phone
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // "" means ErrInvalid
+	}{
+		{"081-234-5678", "+66812345678"},
+		{"0812345678", "+66812345678"},
+		{"+66812345678", "+66812345678"},
+		{"+66 81 234 5678", "+66812345678"},
+		{" (081) 234.5678 ", "+66812345678"},
+		{"0066812345678", "+66812345678"},
+		{"66812345678", "+66812345678"},
+		{"+66 081 234 5678", "+66812345678"},
+		{"660812345678", "+66812345678"},
+		{"02-123-4567", "+6621234567"},
+		{"+66 2 123 4567", "+6621234567"},
+		{"+1 415 555 0100", "+14155550100"},
+		{"08123456789", ""},
+		{"0812", ""},
+		{"+668123456789", ""},
+		{"+1234567", ""},
+		{"812345678", ""},
+		{"08a2345678", ""},
+		{"081-234-567x", ""},
+		{"+", ""},
+		{"", ""},
+		{"++66812345678", ""},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.in)
+		if tt.want == "" {
+			if err != ErrInvalid {
+				t.Errorf("Normalize(%q) = %q, %v, want ErrInvalid", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSearchPrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"0", "+66"},
+		{"081", "+6681"},
+		{"081-23", "+668123"},
+		{"+66", "+66"},
+		{"+66 8", "+668"},
+		{"0066 8", "+668"},
+		{"6608", "+668"},
+		{"668", "+668"},
+		{"0812345678", "+66812345678"},
+		{"", ""},
+		{"+", ""},
+		{"08a", ""},
+	}
+	for _, tt := range tests {
+		if got := SearchPrefix(tt.in); got != tt.want {
+			t.Errorf("SearchPrefix(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -3,28 +3,116 @@ package router
 import (
 	"database/sql"
 	"delivery_webservice/api" // Import the api package
+	"delivery_webservice/auth"
+	"delivery_webservice/notify"
+	"delivery_webservice/storage"
 	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
-func InitRoutes(db *sql.DB) *mux.Router {
+// allow restricts a handler to logged-in callers holding one of the given roles
+func allow(h http.HandlerFunc, roles ...string) http.Handler {
+	return api.RequireRole(roles...)(h)
+}
+
+// allowKey is like allow, and also accepts merchant API keys that were granted scope
+func allowKey(h http.HandlerFunc, scope string, roles ...string) http.Handler {
+	return api.RequireAccess(api.Access{Roles: roles, Scope: scope})(h)
+}
+
+// anyRole lists every role, for routes open to all logged-in callers
+var anyRole = []string{auth.RoleUser, auth.RoleRider, auth.RoleAdmin}
+
+func InitRoutes(db *sql.DB, sms notify.SMSSender, store storage.BlobStore) *mux.Router {
 	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(api.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(api.MethodNotAllowed)
 
 	// Example route
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Hello, World!"))
 	}).Methods("GET")
 
-	// Register rider route
-	r.HandleFunc("/api/rider/register", api.RegisterRider(db)).Methods("POST")
+	// Public routes: registration, phone verification, login, token refresh and password reset
+	r.HandleFunc("/api/rider/register", api.RegisterRider(db, sms)).Methods("POST")
 	r.HandleFunc("/api/auth/login", api.LoginUserOrRider(db)).Methods("POST")
-	r.HandleFunc("/api/user/register", api.RegisterUser(db)).Methods("POST")
+	r.HandleFunc("/api/user/register", api.RegisterUser(db, sms)).Methods("POST")
+	r.HandleFunc("/api/auth/verify-phone", api.VerifyPhone(db)).Methods("POST")
+	r.HandleFunc("/api/auth/verify-phone/resend", api.ResendVerificationCode(db, sms)).Methods("POST")
+	r.HandleFunc("/api/auth/refresh", api.RefreshSession(db)).Methods("POST")
+	r.HandleFunc("/api/auth/logout", api.Logout(db)).Methods("POST")
+	r.HandleFunc("/api/auth/password/forgot", api.ForgotPassword(db, sms)).Methods("POST")
+	r.HandleFunc("/api/auth/password/reset", api.ResetPassword(db)).Methods("POST")
+
+	// Uploaded files are served from here when they are kept on local disk
+	if local, ok := store.(*storage.LocalBlobStore); ok {
+		r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", local.Handler())).Methods("GET")
+	}
+
+	// Every other route requires a valid access token, or an API key where allowKey says so
+	protected := r.NewRoute().Subrouter()
+	protected.Use(api.Authenticate(db))
+
+	// Any logged-in role
+	protected.Handle("/api/auth/logout-all", allow(api.LogoutAllDevices(db), anyRole...)).Methods("POST")
+	protected.Handle("/api/auth/password/change", allow(api.ChangePassword(db), anyRole...)).Methods("POST")
+	protected.Handle("/api/auth/switch-role", allow(api.SwitchRole(db), anyRole...)).Methods("POST")
+	protected.Handle("/api/auth/sessions", allow(api.ListSessions(db), anyRole...)).Methods("GET")
+	protected.Handle("/api/auth/sessions/{session_id}", allow(api.RevokeSession(db), anyRole...)).Methods("DELETE")
+	protected.Handle("/api/account/export", allow(api.ExportMyData(db), anyRole...)).Methods("GET")
+	protected.Handle("/api/account/delete", allow(api.DeleteMyAccount(db, store), anyRole...)).Methods("POST")
+	protected.Handle("/api/uploads", allow(api.UploadImage(db, store), anyRole...)).Methods("POST")
+	protected.Handle("/api/account/phone/confirm", allow(api.ConfirmPhoneChange(db), anyRole...)).Methods("POST")
+
+	// Sender profile
+	protected.Handle("/api/user/me", allow(api.GetMyUserProfile(db), auth.RoleUser)).Methods("GET")
+	protected.Handle("/api/user/me", allow(api.UpdateMyUserProfile(db, sms), auth.RoleUser)).Methods("PATCH")
+
+	// Saved addresses
+	protected.Handle("/api/user/addresses", allow(api.ListAddresses(db), auth.RoleUser)).Methods("GET")
+	protected.Handle("/api/user/addresses", allow(api.CreateAddress(db), auth.RoleUser)).Methods("POST")
+	protected.Handle("/api/user/addresses/{address_id}", allow(api.GetAddress(db), auth.RoleUser)).Methods("GET")
+	protected.Handle("/api/user/addresses/{address_id}", allow(api.UpdateAddress(db), auth.RoleUser)).Methods("PATCH")
+	protected.Handle("/api/user/addresses/{address_id}", allow(api.DeleteAddress(db), auth.RoleUser)).Methods("DELETE")
+
+	// Rider profile
+	protected.Handle("/api/rider/me", allow(api.GetMyRiderProfile(db), auth.RoleRider)).Methods("GET")
+	protected.Handle("/api/rider/me", allow(api.UpdateMyRiderProfile(db, sms), auth.RoleRider)).Methods("PATCH")
+	protected.Handle("/api/riders/{rider_id}", allow(api.GetRider(db), anyRole...)).Methods("GET")
+	protected.Handle("/api/rider/jobs", allow(api.ListJobs(db), auth.RoleRider)).Methods("GET")
+
+	// Rider verification: documents are uploaded, then submitted for an admin to review
+	protected.Handle("/api/rider/verification", allow(api.GetMyRiderVerification(db), auth.RoleRider)).Methods("GET")
+	protected.Handle("/api/rider/verification/submit", allow(api.SubmitRiderVerification(db), auth.RoleRider)).Methods("POST")
+	protected.Handle("/api/rider/documents/{doc_type}", allow(api.UploadRiderDocument(db, store), auth.RoleRider)).Methods("POST")
+
 	// Route สำหรับการสร้างการจัดส่ง
-	r.HandleFunc("/create-delivery", api.CreateDelivery(db)).Methods("POST")
-	r.HandleFunc("/search-user", api.SearchReceiverByPhone(db)).Methods("POST")
-	r.HandleFunc("/get/list_user_send/{sender_id}", api.GetDeliveryBySender(db)).Methods("POST")
-	r.HandleFunc("/get/rider/{rider_id}", api.GetRider(db)).Methods("POST")
+	protected.Handle("/create-delivery", allowKey(api.CreateDelivery(db), auth.ScopeDeliveriesCreate, auth.RoleUser)).Methods("POST")
+	protected.Handle("/search-user", allowKey(api.SearchReceiverByPhone(db), auth.ScopeDeliveriesCreate, auth.RoleUser)).Methods("POST")
+	protected.Handle("/get/list_user_send/{sender_id}", allowKey(api.GetDeliveryBySender(db), auth.ScopeDeliveriesRead, auth.RoleUser, auth.RoleAdmin)).Methods("POST")
+	protected.Handle("/get/rider/{rider_id}", allow(api.GetRider(db), anyRole...)).Methods("POST")
+	protected.Handle("/api/shipments/{shipment_id}/rating", allow(api.RateRider(db), auth.RoleUser)).Methods("POST")
+	protected.Handle("/api/shipments/{shipment_id}/status", allow(api.UpdateShipmentStatus(db), anyRole...)).Methods("POST")
+	protected.Handle("/api/shipments/{shipment_id}/accept", allow(api.AcceptJob(db), auth.RoleRider)).Methods("POST")
+	protected.Handle("/api/shipments/{shipment_id}/history", allow(api.GetShipmentStatusHistory(db), anyRole...)).Methods("GET")
+	protected.Handle("/api/shipments/{shipment_id}/proofs/{stage}", allow(api.SubmitShipmentProof(db, store), auth.RoleRider)).Methods("POST")
+	protected.Handle("/api/shipments/{shipment_id}/proofs/{stage}/photo", allow(api.GetShipmentProofPhoto(db, store), anyRole...)).Methods("GET")
+	protected.Handle("/api/shipments/{shipment_id}", allow(api.GetShipment(db), anyRole...)).Methods("GET")
+
+	// Admin only
+	protected.Handle("/api/admin/login-lockouts", allow(api.ListLoginLockouts(db), auth.RoleAdmin)).Methods("GET")
+	protected.Handle("/api/admin/accounts", allow(api.ListAccounts(db), auth.RoleAdmin)).Methods("GET")
+	protected.Handle("/api/admin/accounts/{account_id}", allow(api.GetAccount(db), auth.RoleAdmin)).Methods("GET")
+	protected.Handle("/api/admin/accounts/{account_id}/suspend", allow(api.SuspendAccount(db), auth.RoleAdmin)).Methods("POST")
+	protected.Handle("/api/admin/accounts/{account_id}/reinstate", allow(api.ReinstateAccount(db), auth.RoleAdmin)).Methods("POST")
+	protected.Handle("/api/admin/api-keys", allow(api.CreateAPIKey(db), auth.RoleAdmin)).Methods("POST")
+	protected.Handle("/api/admin/api-keys", allow(api.ListAPIKeys(db), auth.RoleAdmin)).Methods("GET")
+	protected.Handle("/api/admin/api-keys/{key_id}/revoke", allow(api.RevokeAPIKey(db), auth.RoleAdmin)).Methods("POST")
+	protected.Handle("/api/admin/rider-verifications", allow(api.ListRiderVerifications(db), auth.RoleAdmin)).Methods("GET")
+	protected.Handle("/api/admin/riders/{rider_id}/verification", allow(api.ReviewRiderVerification(db), auth.RoleAdmin)).Methods("POST")
+	protected.Handle("/api/admin/riders/{rider_id}/documents/{doc_type}", allow(api.GetRiderDocument(db, store), auth.RoleAdmin)).Methods("GET")
+	protected.Handle("/api/admin/audit-log", allow(api.ListAuditLog(db), auth.RoleAdmin)).Methods("GET")
 
 	return r
 }
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// BlobStore keeps uploaded files under a key and serves them from a stable URL
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// PrivatePrefix starts the keys of files that must never be served publicly, such as identity
+// documents. They are only read back through Get by an authorized handler.
+const PrivatePrefix = "private/"
+
+// PublicPrefixes start the keys of files clients fetch straight from URL, such as profile photos.
+// On S3 only these prefixes are readable without credentials.
+var PublicPrefixes = []string{"profile/", "item/"}
+
+var errInvalidKey = errors.New("invalid object key")
+
+// validKey rejects keys that could escape the store, such as absolute paths or ".." segments
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
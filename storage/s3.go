@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3BlobStore keeps files in a bucket of any S3-compatible service, such as AWS S3 or MinIO
+type S3BlobStore struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+// NewS3BlobStore connects to endpoint (host[:port]), creates bucket if it does not exist and sets
+// its policy to publicReadPolicy.
+// publicURL is where clients fetch objects from; empty means endpoint/bucket.
+func NewS3BlobStore(ctx context.Context, endpoint, accessKey, secretKey, bucket string, useSSL bool, publicURL string) (*S3BlobStore, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("checking bucket %s: %w", bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, fmt.Errorf("creating bucket %s: %w", bucket, err)
+		}
+	}
+
+	if err := client.SetBucketPolicy(ctx, bucket, publicReadPolicy(bucket)); err != nil {
+		return nil, fmt.Errorf("setting policy of bucket %s: %w", bucket, err)
+	}
+
+	if publicURL == "" {
+		scheme := "http"
+		if useSSL {
+			scheme = "https"
+		}
+		publicURL = fmt.Sprintf("%s://%s/%s", scheme, endpoint, bucket)
+	}
+	return &S3BlobStore{client: client, bucket: bucket, publicURL: strings.TrimRight(publicURL, "/")}, nil
+}
+
+// publicReadPolicy lets anyone read objects under PublicPrefixes, so the URLs from URL work, and
+// nothing else. It replaces whatever policy the bucket had.
+func publicReadPolicy(bucket string) string {
+	resources := make([]string, len(PublicPrefixes))
+	for i, prefix := range PublicPrefixes {
+		resources[i] = "arn:aws:s3:::" + bucket + "/" + prefix + "*"
+	}
+	policy, _ := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Effect":    "Allow",
+			"Principal": map[string][]string{"AWS": {"*"}},
+			"Action":    []string{"s3:GetObject"},
+			"Resource":  resources,
+		}},
+	})
+	return string(policy)
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return errInvalidKey
+	}
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	// Keys are never reused, so clients and CDNs may cache public objects forever
+	if !strings.HasPrefix(key, PrivatePrefix) {
+		opts.CacheControl = "public, max-age=31536000, immutable"
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, opts)
+	return err
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, errInvalidKey
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat surfaces a missing key now rather than on the first Read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return errInvalidKey
+	}
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3BlobStore) URL(key string) string {
+	return s.publicURL + "/" + key
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestS3BlobStore connects to the MinIO or S3 service named by TEST_S3_ENDPOINT, with
+// TEST_S3_ACCESS_KEY, TEST_S3_SECRET_KEY and optionally TEST_S3_BUCKET and TEST_S3_USE_SSL=true.
+// Tests that need it are skipped when TEST_S3_ENDPOINT is not set.
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	TEST_S3_ENDPOINT=localhost:9000 TEST_S3_ACCESS_KEY=minioadmin TEST_S3_SECRET_KEY=minioadmin go test ./storage
+func newTestS3BlobStore(t *testing.T) *S3BlobStore {
+	t.Helper()
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT is not set")
+	}
+	bucket := os.Getenv("TEST_S3_BUCKET")
+	if bucket == "" {
+		bucket = "blobstore-test"
+	}
+	store, err := NewS3BlobStore(context.Background(), endpoint, os.Getenv("TEST_S3_ACCESS_KEY"),
+		os.Getenv("TEST_S3_SECRET_KEY"), bucket, os.Getenv("TEST_S3_USE_SSL") == "true", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestS3BlobStorePutGetDelete(t *testing.T) {
+	store := newTestS3BlobStore(t)
+	ctx := context.Background()
+
+	for _, prefix := range []string{PublicPrefixes[0], PrivatePrefix + "test/"} {
+		t.Run(prefix, func(t *testing.T) {
+			key := fmt.Sprintf("%s%d.txt", prefix, time.Now().UnixNano())
+			data := []byte("blob store test")
+			if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			t.Cleanup(func() { store.Delete(ctx, key) })
+
+			file, err := store.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			got, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				t.Fatalf("reading object: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("Get returned %q, want %q", got, data)
+			}
+
+			// Only public prefixes can be fetched from URL without credentials
+			resp, err := http.Get(store.URL(key))
+			if err != nil {
+				t.Fatalf("fetching URL: %v", err)
+			}
+			resp.Body.Close()
+			public := prefix != PrivatePrefix+"test/"
+			if public && resp.StatusCode != http.StatusOK {
+				t.Errorf("anonymous GET of public object: status %d, want 200", resp.StatusCode)
+			}
+			if !public && resp.StatusCode == http.StatusOK {
+				t.Error("anonymous GET of private object succeeded")
+			}
+
+			if err := store.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := store.Get(ctx, key); err == nil {
+				t.Fatal("Get after Delete succeeded")
+			}
+		})
+	}
+}
+
+func TestS3BlobStoreRejectsInvalidKeys(t *testing.T) {
+	store := newTestS3BlobStore(t)
+	ctx := context.Background()
+	for _, key := range []string{"", "/abs", "a/../b", "a//b", `a\b`} {
+		if err := store.Put(ctx, key, bytes.NewReader(nil), 0, "text/plain"); err != errInvalidKey {
+			t.Errorf("Put(%q) = %v, want errInvalidKey", key, err)
+		}
+		if _, err := store.Get(ctx, key); err != errInvalidKey {
+			t.Errorf("Get(%q) = %v, want errInvalidKey", key, err)
+		}
+		if err := store.Delete(ctx, key); err != errInvalidKey {
+			t.Errorf("Delete(%q) = %v, want errInvalidKey", key, err)
+		}
+	}
+}